@@ -0,0 +1,70 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+)
+
+// AddSharedRule adds a single guard to every given transition, like
+// adding it to each of them with AddRule. PermittedMany runs it only once.
+func (r *Ruleset) AddSharedRule(guard Guard, transitions ...Transition) {
+	entry := newGuard(guard)
+	entry.shared = true
+	for _, t := range transitions {
+		r.addGuards(key(t), entry)
+	}
+}
+
+// PermittedMany checks many goals from start at once and reports, by goal
+// ID, which ones are permitted. The guards added with AddSharedRule are
+// run at most once for the whole batch, their result being reused for the
+// other goals, while the other guards (origin, exit and global ones
+// included) are run at most once per goal as they may depend on it.
+func (r *Ruleset) PermittedMany(start *State, goals ...State) map[ID]bool {
+	ctx := r.withRuleset(context.Background())
+	results := map[result]error{}
+	permitted := make(map[ID]bool, len(goals))
+
+	for _, goal := range goals {
+		steps, ok := r.lookup(T{start.ID(), goal.ID()}, &goal, PolicyDeny)
+		permitted[goal.ID()] = ok
+		for _, group := range [][]step{steps, r.invariantSteps(goal.ID(), len(steps))} {
+			if ok && !r.passes(ctx, results, start, goal, group) {
+				permitted[goal.ID()] = false
+				break
+			}
+		}
+	}
+	return permitted
+}
+
+// result identifies the result of a guard in PermittedMany, the goal being
+// nil for the shared guards
+type result struct {
+	id   uintptr
+	goal ID
+}
+
+// passes runs the guards for PermittedMany, reusing and recording their
+// results, and reports whether they all passed
+func (r *Ruleset) passes(ctx context.Context, results map[result]error, start *State, goal State, steps []step) bool {
+	for _, guard := range steps {
+		k := result{id: guard.id}
+		if !guard.shared {
+			k.goal = goal.ID()
+		}
+		err, ok := results[k]
+		if !ok {
+			s, g := guardCopy(*start), guardCopy(goal)
+			err = guard.run(ctx, &s, &g)
+			results[k] = err
+		}
+		if errors.Is(err, Allow) {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package fsm_test
+
+import (
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestRulesetPermittedMany(t *testing.T) {
+	cancelled := fsm.NewState(fsm.String("cancelled"))
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(statePending, stateFinished),
+		fsm.NewTransition(statePending, cancelled),
+	)
+	var shared, global, denied int
+	rules.AddSharedRule(countingGuard(nil, &shared),
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(statePending, stateFinished),
+	)
+	rules.AddRule(fsm.NewTransition(statePending, stateFinished), countingGuard(testError, &denied))
+	rules.AddGlobalGuard(countingGuard(nil, &global))
+
+	st.Expect(t, rules.PermittedMany(&statePending, stateStarted, stateFinished, cancelled, stateNone), map[fsm.ID]bool{
+		stateStarted.ID():  true,
+		stateFinished.ID(): false,
+		cancelled.ID():     true,
+		stateNone.ID():     false,
+	})
+	st.Expect(t, shared, 1)
+	st.Expect(t, global, 2)
+	st.Expect(t, denied, 1)
+
+	// the shared guard is still run for every transition by Permitted
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	st.Reject(t, rules.Permitted(&statePending, &stateFinished), nil)
+	st.Expect(t, shared, 3)
+}
+
+func TestRulesetPermittedManyGoalGuards(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(statePending, stateFinished),
+	)
+	rules.AddGlobalGuard(func(start *fsm.State, goal *fsm.State) error {
+		if goal.ID() == stateFinished.ID() {
+			return testError
+		}
+		return nil
+	})
+
+	st.Expect(t, rules.PermittedMany(&statePending, stateStarted, stateFinished), map[fsm.ID]bool{
+		stateStarted.ID():  true,
+		stateFinished.ID(): false,
+	})
+	st.Expect(t, rules.PermittedMany(&statePending, stateFinished, stateStarted), map[fsm.ID]bool{
+		stateStarted.ID():  true,
+		stateFinished.ID(): false,
+	})
+}
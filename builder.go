@@ -0,0 +1,64 @@
+package fsm
+
+// Builder defines a ruleset by chaining calls, e.g.
+//
+//	rules := fsm.NewBuilder().
+//		From(pending).To(started).When(paid).Add().
+//		To(cancelled).Add().
+//		Build()
+//
+// which is the same as calling AddRule, or AddTransition for transitions
+// without guards, on a Ruleset.
+type Builder struct {
+	rules  Ruleset
+	origin IDer
+	exit   IDer
+	guards []Guard
+}
+
+// NewBuilder creates a builder of an empty ruleset
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// From sets the origin of the next transitions added
+func (b *Builder) From(origin IDer) *Builder {
+	b.origin = origin
+	return b
+}
+
+// To sets the exit of the next transition added
+func (b *Builder) To(exit IDer) *Builder {
+	b.exit = exit
+	return b
+}
+
+// When adds guards to the next transition added
+func (b *Builder) When(guards ...Guard) *Builder {
+	b.guards = append(b.guards, guards...)
+	return b
+}
+
+// Add adds the transition from the origin to the exit with the guards
+// given to When, with a default rule when there are none (see
+// AddTransition). The exit and guards are then cleared while the origin is
+// kept for the next transitions. Add panics if From or To wasn't called.
+func (b *Builder) Add() *Builder {
+	if b.origin == nil || b.exit == nil {
+		panic("fsm: Builder.Add called without From and To")
+	}
+	t := NewTransition(b.origin, b.exit)
+	if len(b.guards) == 0 {
+		b.rules.AddTransition(t)
+	} else {
+		b.rules.AddRule(t, b.guards...)
+	}
+	b.exit, b.guards = nil, nil
+	return b
+}
+
+// Build returns the ruleset defined so far, the builder shouldn't be used
+// afterwards
+func (b *Builder) Build() Ruleset {
+	return b.rules
+}
@@ -0,0 +1,46 @@
+package fsm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestBuilder(t *testing.T) {
+	cancelled := fsm.NewState(fsm.String("cancelled"))
+	paid := false
+	isPaid := func(start *fsm.State, goal *fsm.State) error {
+		if !paid {
+			return testError
+		}
+		return nil
+	}
+
+	built := fsm.NewBuilder().
+		From(statePending).To(stateStarted).When(isPaid).Add().
+		To(cancelled).Add().
+		From(stateStarted).To(stateFinished).Add().
+		Build()
+
+	expected := fsm.Ruleset{}
+	expected.AddRule(fsm.NewTransition(statePending, stateStarted), isPaid)
+	expected.AddTransition(fsm.NewTransition(statePending, cancelled))
+	expected.AddTransition(fsm.NewTransition(stateStarted, stateFinished))
+
+	st.Expect(t, built.Transitions(), expected.Transitions())
+	for _, tr := range expected.Transitions() {
+		st.Expect(t, built.GuardCount(tr), expected.GuardCount(tr))
+	}
+	st.Expect(t, errors.Is(built.Permitted(&statePending, &stateStarted), testError), true)
+	paid = true
+	st.Expect(t, built.Permitted(&statePending, &stateStarted), nil)
+	st.Expect(t, built.Permitted(&statePending, &cancelled), nil)
+	st.Expect(t, built.Permitted(&stateStarted, &cancelled).Error(), "No rules found for started to cancelled")
+
+	defer func() {
+		st.Expect(t, recover(), "fsm: Builder.Add called without From and To")
+	}()
+	fsm.NewBuilder().To(stateStarted).Add()
+}
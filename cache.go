@@ -0,0 +1,100 @@
+package fsm
+
+import (
+	"context"
+	"sync"
+)
+
+// AddRuleCacheable is like AddRule but the results of the guards can be
+// cached by machines created with WithGuardCache. Only guards whose result
+// depends on nothing but the states should be cacheable, guards reading
+// external mutable state (e.g. a database) shouldn't.
+func (r *Ruleset) AddRuleCacheable(t Transition, guards ...Guard) {
+	entries := make([]guardEntry, len(guards))
+	for i, guard := range guards {
+		entries[i] = newGuard(guard)
+		entries[i].cacheable = true
+	}
+	r.addGuards(key(t), entries...)
+}
+
+// WithGuardCache makes the machine cache the results of the cacheable
+// guards (see AddRuleCacheable) by current state and goal, so checking
+// the same transition again, e.g. with CanTransition, doesn't run them.
+// The cache is cleared whenever the machine changes state; InvalidateCache
+// clears it when something else the guards depend on changed, such as the
+// rules. Machines created by For and Clone start with an empty cache.
+func WithGuardCache() func(*Machine) {
+	return func(m *Machine) {
+		m.eval.cache = &guardCache{}
+	}
+}
+
+// InvalidateCache clears the guard results cached by the machine, see
+// WithGuardCache
+func (m *Machine) InvalidateCache() {
+	m.eval.cache.clear()
+}
+
+// guardCache holds the results of cacheable guards by transition and
+// position among the steps, a nil cache caching nothing
+type guardCache struct {
+	mu      sync.Mutex
+	results map[T]map[int]error
+}
+
+// wrap returns the steps for the attempt, the cacheable ones using the
+// cache
+func (c *guardCache) wrap(attempt T, steps []step) []step {
+	for i := range steps {
+		if !steps[i].cacheable {
+			continue
+		}
+		index, check := steps[i].index, steps[i].check
+		steps[i].check = func(ctx context.Context, start *State, goal *State) error {
+			if err, ok := c.get(attempt, index); ok {
+				return err
+			}
+			err := check(ctx, start, goal)
+			if ctx.Err() == nil {
+				c.set(attempt, index, err)
+			}
+			return err
+		}
+	}
+	return steps
+}
+
+// get returns the cached result of the guard, and whether there is one
+func (c *guardCache) get(attempt T, index int) (error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err, ok := c.results[attempt][index]
+	return err, ok
+}
+
+// set caches the result of the guard
+func (c *guardCache) set(attempt T, index int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.results == nil {
+		c.results = map[T]map[int]error{}
+	}
+	if c.results[attempt] == nil {
+		c.results[attempt] = map[int]error{}
+	}
+	c.results[attempt][index] = err
+}
+
+// clear drops the cached results
+func (c *guardCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.results = nil
+}
@@ -0,0 +1,53 @@
+package fsm_test
+
+import (
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestMachineGuardCache(t *testing.T) {
+	var cached, uncached int
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, statePending),
+	)
+	rules.AddRuleCacheable(fsm.NewTransition(statePending, stateStarted), countingGuard(nil, &cached))
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), countingGuard(nil, &uncached))
+
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithGuardCache())
+	m.Rules = &rules
+	for i := 0; i < 3; i++ {
+		st.Expect(t, m.CanTransition(stateStarted), true)
+	}
+	st.Expect(t, cached, 1)
+	st.Expect(t, uncached, 3)
+
+	m.InvalidateCache()
+	st.Expect(t, m.CanTransition(stateStarted), true)
+	st.Expect(t, cached, 2)
+
+	// changing state clears the cache
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, cached, 2)
+	st.Expect(t, m.Transition(statePending), nil)
+	st.Expect(t, m.CanTransition(stateStarted), true)
+	st.Expect(t, cached, 3)
+
+	// failures are cached too
+	var failed int
+	rules.AddRuleCacheable(fsm.NewTransition(stateStarted, statePending), countingGuard(testError, &failed))
+	n := m.For(stateStarted)
+	st.Expect(t, n.CanTransition(statePending), false)
+	st.Expect(t, n.CanTransition(statePending), false)
+	st.Expect(t, failed, 1)
+
+	// without WithGuardCache nothing is cached
+	o := fsm.New(fsm.WithInitialState(statePending))
+	o.Rules = &rules
+	o.InvalidateCache()
+	st.Expect(t, o.CanTransition(stateStarted), true)
+	st.Expect(t, o.CanTransition(stateStarted), true)
+	st.Expect(t, cached, 5)
+}
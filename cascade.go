@@ -0,0 +1,48 @@
+package fsm
+
+import "context"
+
+// AddCascade makes TransitionCascade attempt a transition to attempt once
+// the machine entered after, e.g. entering "paid" attempting "fulfilled"
+// which is only permitted when the inventory is available. Cascades added
+// for the same state are attempted in order, the first permitted being
+// performed.
+func (r *Ruleset) AddCascade(after IDer, attempt State) {
+	if r.cascades == nil {
+		r.cascades = map[ID][]State{}
+	}
+	r.cascades[after.ID()] = append(r.cascades[after.ID()], attempt)
+}
+
+// TransitionCascade is like Transition but then follows the cascades of the
+// states entered (see AddCascade), until no cascade of the current state is
+// permitted or it would enter a state already entered, and returns the
+// state the machine rests at. Only the error of the transition to the goal
+// is returned, a denied cascade just stops there. Other transition methods
+// don't follow cascades.
+func (m *Machine) TransitionCascade(goal State) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ctx := context.Background()
+	if err := m.transition(ctx, goal); err != nil {
+		return m.State, err
+	}
+
+	entered := map[ID]bool{goal.ID(): true}
+	for m.cascade(ctx, entered) {
+	}
+	return m.State, nil
+}
+
+// cascade performs the first permitted cascade of the current state not
+// entering a state already entered, and reports whether there was one
+func (m *Machine) cascade(ctx context.Context, entered map[ID]bool) bool {
+	for _, attempt := range m.Rules.cascades[m.State.ID()] {
+		if !entered[attempt.ID()] && m.transition(ctx, attempt) == nil {
+			entered[attempt.ID()] = true
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package fsm_test
+
+import (
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestMachineTransitionCascade(t *testing.T) {
+	paid := fsm.NewState(fsm.String("paid"))
+	fulfilled := fsm.NewState(fsm.String("fulfilled"))
+	shipped := fsm.NewState(fsm.String("shipped"))
+	inStock := true
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, paid),
+		fsm.NewTransition(paid, fulfilled),
+		fsm.NewTransition(fulfilled, shipped),
+	)
+	rules.AddRule(fsm.NewTransition(paid, fulfilled), func(start *fsm.State, goal *fsm.State) error {
+		if !inStock {
+			return testError
+		}
+		return nil
+	})
+	rules.AddCascade(paid, fulfilled)
+	rules.AddCascade(fulfilled, shipped)
+
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithHistory())
+	m.Rules = &rules
+	rest, err := m.TransitionCascade(paid)
+	st.Expect(t, err, nil)
+	st.Expect(t, rest, shipped)
+	st.Expect(t, len(m.History()), 3)
+
+	// a blocked cascade leaves the machine at the state entered
+	inStock = false
+	st.Expect(t, m.Reset(), nil)
+	rest, err = m.TransitionCascade(paid)
+	st.Expect(t, err, nil)
+	st.Expect(t, rest, paid)
+
+	// other methods don't cascade
+	st.Expect(t, m.Reset(), nil)
+	inStock = true
+	st.Expect(t, m.Transition(paid), nil)
+	st.Expect(t, m.Current(), paid)
+
+	// the transition to the goal fails as usual
+	rest, err = m.TransitionCascade(shipped)
+	st.Expect(t, err.Error(), "No rules found for paid to shipped")
+	st.Expect(t, rest, paid)
+}
+
+func TestMachineTransitionCascadeCycle(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+		fsm.NewTransition(stateFinished, stateStarted),
+	)
+	rules.AddCascade(stateStarted, stateFinished)
+	rules.AddCascade(stateFinished, stateStarted)
+
+	m := fsm.New(fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	rest, err := m.TransitionCascade(stateStarted)
+	st.Expect(t, err, nil)
+	st.Expect(t, rest, stateFinished)
+
+	clone := rules.Clone()
+	m.Rules = &clone
+	st.Expect(t, m.Reset(), nil)
+	rest, _ = m.TransitionCascade(stateStarted)
+	st.Expect(t, rest, stateFinished)
+}
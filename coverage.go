@@ -0,0 +1,81 @@
+package fsm
+
+import (
+	"sync"
+	"time"
+)
+
+// CoverageTracker records the transitions performed by a Machine, to check
+// that tests exercise all of its rules, see Uncovered. It is meant for
+// tests, e.g. reporting the uncovered transitions from TestMain.
+type CoverageTracker struct {
+	mu      sync.Mutex
+	rules   *Ruleset
+	covered map[T]bool
+	next    Observer
+}
+
+// NewCoverageTracker starts tracking the transitions of m against its current
+// rules. It observes m, the observer m already had, if any, still being
+// notified.
+func NewCoverageTracker(m *Machine) *CoverageTracker {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := &CoverageTracker{
+		rules:   m.Rules,
+		covered: map[T]bool{},
+		next:    m.observer,
+	}
+	m.observer = c
+	return c
+}
+
+// Uncovered returns the transitions of the rules which were never
+// performed, in the order of Ruleset.Transitions. A transition allowed
+// through a parent or AnyState covers the rule it used.
+func (c *CoverageTracker) Uncovered() []Transition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var uncovered []Transition
+	for _, t := range c.rules.sortedTransitions() {
+		if !c.covered[t] {
+			uncovered = append(uncovered, t)
+		}
+	}
+	return uncovered
+}
+
+// TransitionAttempted implements Observer
+func (c *CoverageTracker) TransitionAttempted(t Transition) {
+	if c.next != nil {
+		c.next.TransitionAttempted(t)
+	}
+}
+
+// TransitionAllowed implements Observer, recording the rule of t as covered
+func (c *CoverageTracker) TransitionAllowed(t Transition) {
+	if rule, ok := c.rules.ruleFor(t.Origin(), t.Exit()); ok {
+		c.mu.Lock()
+		c.covered[rule] = true
+		c.mu.Unlock()
+	}
+	if c.next != nil {
+		c.next.TransitionAllowed(t)
+	}
+}
+
+// TransitionDenied implements Observer
+func (c *CoverageTracker) TransitionDenied(t Transition, reason error) {
+	if c.next != nil {
+		c.next.TransitionDenied(t, reason)
+	}
+}
+
+// GuardDuration implements Observer
+func (c *CoverageTracker) GuardDuration(t Transition, d time.Duration) {
+	if c.next != nil {
+		c.next.GuardDuration(t, d)
+	}
+}
@@ -0,0 +1,40 @@
+package fsm_test
+
+import (
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestCoverageTracker(t *testing.T) {
+	cancelled := fsm.NewState(fsm.String("cancelled"))
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+		fsm.NewTransition(fsm.AnyState, cancelled),
+	)
+	o := &recordingObserver{}
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithObserver(o))
+	m.Rules = &rules
+	c := fsm.NewCoverageTracker(m)
+
+	st.Expect(t, len(c.Uncovered()), 3)
+
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, m.Transition(statePending) != nil, true)
+	st.Expect(t, c.Uncovered(), []fsm.Transition{
+		fsm.T{O: fsm.AnyState.ID(), E: cancelled.ID()},
+		fsm.T{O: stateStarted.ID(), E: stateFinished.ID()},
+	})
+
+	// moving to cancelled through AnyState covers its rule
+	st.Expect(t, m.Transition(cancelled), nil)
+	st.Expect(t, c.Uncovered(), []fsm.Transition{
+		fsm.T{O: stateStarted.ID(), E: stateFinished.ID()},
+	})
+
+	// the previous observer is still notified
+	st.Expect(t, o.events[0], "attempted pending->started")
+	st.Expect(t, len(o.events), 6)
+}
@@ -0,0 +1,89 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	errUnknownDimensionFormat = "%w %q"
+)
+
+var (
+	// ErrUnknownDimension is returned when transitioning a dimension which
+	// has no machine, see Dimensions
+	ErrUnknownDimension = errors.New("unknown dimension")
+)
+
+// Dimensions holds the independent states of a subject having orthogonal
+// lifecycles, e.g. the payment and the fulfillment of an order. Each
+// dimension is a Machine with its own rules, state and options, added with
+// WithDimension; transitioning one never affects the others.
+type Dimensions struct {
+	mu       sync.RWMutex
+	machines map[string]*Machine
+}
+
+// NewDimensions creates a subject without any dimension
+func NewDimensions() *Dimensions {
+	return &Dimensions{machines: map[string]*Machine{}}
+}
+
+// WithDimension makes the machine the dimension of d with the given name,
+// replacing the machine it had if any. Machines created from it by For
+// and Clone aren't part of d.
+func WithDimension(d *Dimensions, name string) func(*Machine) {
+	return func(m *Machine) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		d.machines[name] = m
+	}
+}
+
+// Machine returns the machine of the dimension, and whether there is one
+func (d *Dimensions) Machine(name string) (*Machine, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	m, ok := d.machines[name]
+	return m, ok
+}
+
+// Names returns the sorted names of the dimensions
+func (d *Dimensions) Names() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	names := make([]string, 0, len(d.machines))
+	for name := range d.machines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// States returns the current state of every dimension
+func (d *Dimensions) States() map[string]State {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	states := make(map[string]State, len(d.machines))
+	for name, m := range d.machines {
+		states[name] = m.Current()
+	}
+	return states
+}
+
+// Transition transitions the dimension to the goal, see Machine.Transition.
+// An error wrapping ErrUnknownDimension is returned if there is no such
+// dimension.
+func (d *Dimensions) Transition(name string, goal State) error {
+	m, ok := d.Machine(name)
+	if !ok {
+		return fmt.Errorf(errUnknownDimensionFormat, ErrUnknownDimension, name)
+	}
+	return m.Transition(goal)
+}
@@ -0,0 +1,42 @@
+package fsm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestDimensions(t *testing.T) {
+	unpaid := fsm.NewState(fsm.String("unpaid"))
+	paid := fsm.NewState(fsm.String("paid"))
+	packing := fsm.NewState(fsm.String("packing"))
+	shipped := fsm.NewState(fsm.String("shipped"))
+
+	payment := fsm.CreateRuleset(fsm.NewTransition(unpaid, paid))
+	fulfillment := fsm.CreateRuleset(fsm.NewTransition(packing, shipped))
+
+	order := fsm.NewDimensions()
+	pm := fsm.New(fsm.WithInitialState(unpaid), fsm.WithDimension(order, "payment"))
+	pm.Rules = &payment
+	fm := fsm.New(fsm.WithInitialState(packing), fsm.WithDimension(order, "fulfillment"))
+	fm.Rules = &fulfillment
+	st.Expect(t, order.Names(), []string{"fulfillment", "payment"})
+
+	st.Expect(t, order.Transition("payment", paid), nil)
+	st.Expect(t, order.States(), map[string]fsm.State{"payment": paid, "fulfillment": packing})
+
+	// rules apply within their dimension
+	st.Expect(t, order.Transition("fulfillment", paid).Error(), "No rules found for packing to paid")
+	st.Expect(t, order.Transition("fulfillment", shipped), nil)
+	st.Expect(t, pm.Current(), paid)
+
+	m, ok := order.Machine("fulfillment")
+	st.Expect(t, ok, true)
+	st.Expect(t, m, fm)
+
+	err := order.Transition("shipping", shipped)
+	st.Expect(t, errors.Is(err, fsm.ErrUnknownDimension), true)
+	st.Expect(t, err.Error(), `unknown dimension "shipping"`)
+}
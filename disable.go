@@ -0,0 +1,46 @@
+package fsm
+
+import "errors"
+
+const (
+	errDisabledFormat = "Transition from %s to %s is disabled"
+)
+
+var (
+	// ErrDisabled is the error of the TransitionError returned for a
+	// transition which is disabled, see Ruleset.Disable
+	ErrDisabled = errors.New("transition disabled")
+)
+
+// Disable turns the transition off: it isn't permitted until Enable is
+// called, but unlike RemoveRule its guards, priority, metadata and weight
+// are kept. Attempts fail with a TransitionError wrapping ErrDisabled.
+// Disabling a transition which doesn't exist does nothing.
+func (r *Ruleset) Disable(t Transition) {
+	if _, ok := r.rules[key(t)]; !ok {
+		return
+	}
+	if r.disabled == nil {
+		r.disabled = map[T]bool{}
+	}
+	r.disabled[key(t)] = true
+}
+
+// Enable turns a transition disabled by Disable back on
+func (r *Ruleset) Enable(t Transition) {
+	delete(r.disabled, key(t))
+}
+
+// IsDisabled tells whether the transition is disabled, see Disable
+func (r *Ruleset) IsDisabled(t Transition) bool {
+	return r.disabled[key(t)]
+}
+
+// noRule returns the error for an attempt lookup found no guards for,
+// telling a disabled rule from a missing one
+func (r *Ruleset) noRule(attempt T) *TransitionError {
+	if t, ok := r.ruleFor(attempt.O, attempt.E); ok && r.disabled[t] {
+		return &TransitionError{Transition: attempt, Guard: -1, Err: ErrDisabled}
+	}
+	return &TransitionError{Transition: attempt, Guard: -1}
+}
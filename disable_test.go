@@ -0,0 +1,48 @@
+package fsm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestRulesetDisable(t *testing.T) {
+	rules := fsm.Ruleset{}
+	start := fsm.NewTransition(statePending, stateStarted)
+	rules.AddRule(start, func(start *fsm.State, goal *fsm.State) error {
+		return nil
+	})
+	rules.SetMeta(start, map[string]string{"label": "start"})
+
+	rules.Disable(start)
+	st.Expect(t, rules.IsDisabled(start), true)
+	st.Expect(t, rules.HasRule(start), true)
+	err := rules.Permitted(&statePending, &stateStarted)
+	st.Expect(t, errors.Is(err, fsm.ErrDisabled), true)
+	st.Expect(t, err.Error(), "Transition from pending to started is disabled")
+	ok, reason := rules.PermittedReason(&statePending, &stateStarted)
+	st.Expect(t, ok, false)
+	st.Expect(t, reason, fsm.ReasonDisabled)
+	st.Expect(t, reason.String(), "disabled")
+	st.Expect(t, rules.Describe().Transitions[0].Disabled, true)
+
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithRules(&rules))
+	st.Expect(t, errors.Is(m.Transition(stateStarted), fsm.ErrDisabled), true)
+
+	// disabled transitions survive clones
+	clone := rules.Clone()
+	st.Expect(t, clone.IsDisabled(start), true)
+
+	rules.Enable(start)
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	st.Expect(t, rules.Meta(start), map[string]string{"label": "start"})
+	st.Expect(t, rules.Describe().Transitions[0].Disabled, false)
+	st.Expect(t, clone.IsDisabled(start), true)
+	st.Expect(t, m.Transition(stateStarted), nil)
+
+	// unknown transitions can't be disabled
+	rules.Disable(fsm.NewTransition(stateStarted, stateFinished))
+	st.Expect(t, rules.IsDisabled(fsm.NewTransition(stateStarted, stateFinished)), false)
+}
@@ -0,0 +1,88 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	errUnknownEventFormat = "%w %s from %s"
+)
+
+var (
+	// ErrUnknownEvent is returned when firing an event which isn't
+	// registered for the current state
+	ErrUnknownEvent = errors.New("unknown event")
+)
+
+// Event names something happening to the machine (e.g. "submit",
+// "approve"), which moves it along one of the transitions registered
+// for the event depending on its current state
+type Event string
+
+// AddEvent registers the transitions taken when e is fired, at most one
+// transition per origin. The transitions are still subject to the rules of
+// the ruleset, AddEvent doesn't add any rule on its own.
+func (r *Ruleset) AddEvent(e Event, transitions ...Transition) {
+	if r.events == nil {
+		r.events = map[Event]map[ID]ID{}
+	}
+	if r.events[e] == nil {
+		r.events[e] = map[ID]ID{}
+	}
+	for _, t := range transitions {
+		r.events[e][t.Origin()] = t.Exit()
+	}
+}
+
+// EventTransition returns the transition taken when e is fired from
+// the origin, if any, falling back on the one registered from AnyState
+func (r *Ruleset) EventTransition(e Event, origin ID) (Transition, bool) {
+	exit, ok := r.events[e][origin]
+	if !ok {
+		exit, ok = r.events[e][AnyState.ID()]
+	}
+	if !ok {
+		return nil, false
+	}
+	return T{origin, exit}, true
+}
+
+// Fire resolves the event against the current state and attempts the
+// matching transition. As events only know about IDs, the goal only
+// carries its ID, see IDState. ErrUnknownEvent is returned when the event
+// isn't registered from the current state.
+func (m *Machine) Fire(e Event) error {
+	_, err := m.FireState(e)
+	return err
+}
+
+// FireState is like Fire but also returns the state of the machine once
+// done, the one entered if the transition was performed and the current
+// one otherwise
+func (m *Machine) FireState(e Event) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.validate(); err != nil {
+		return m.State, err
+	}
+	t, ok := m.Rules.EventTransition(e, m.State.ID())
+	if !ok {
+		return m.State, fmt.Errorf(errUnknownEventFormat, ErrUnknownEvent, e, m.State.ID())
+	}
+	err := m.transition(context.Background(), IDState(t.Exit()))
+	return m.State, err
+}
+
+// Fire fires the event on the machine, see Machine.Fire, and returns the
+// ID of its state once done as an S, e.g. a type OrderState string, for
+// callers not to convert it themselves. The ID is converted through its
+// string form (see State.String), so S only suits machines whose state IDs
+// are strings (e.g. String) and for which the conversion can't fail. Use
+// Machine.FireState for other IDs.
+func Fire[S ~string](m *Machine, e Event) (S, error) {
+	state, err := m.FireState(e)
+	return S(state.String()), err
+}
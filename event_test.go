@@ -0,0 +1,70 @@
+package fsm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestMachineFire(t *testing.T) {
+	stateCancelled := fsm.NewState(fsm.String("cancelled"))
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+		fsm.NewTransition(statePending, stateCancelled),
+		fsm.NewTransition(stateStarted, stateCancelled),
+	)
+	rules.AddEvent("advance",
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+	)
+	rules.AddEvent("cancel",
+		fsm.NewTransition(statePending, stateCancelled),
+		fsm.NewTransition(stateStarted, stateCancelled),
+		// registered but not permitted by the rules
+		fsm.NewTransition(stateFinished, stateCancelled),
+	)
+
+	m := fsm.Machine{State: statePending, Rules: &rules}
+	st.Expect(t, m.Fire("advance"), nil)
+	st.Expect(t, m.State.ID(), stateStarted.ID())
+	st.Expect(t, m.Fire("advance"), nil)
+	st.Expect(t, m.State.ID(), stateFinished.ID())
+
+	err := m.Fire("advance")
+	st.Expect(t, errors.Is(err, fsm.ErrUnknownEvent), true)
+	st.Expect(t, err.Error(), "unknown event advance from finished")
+
+	err = m.Fire("cancel")
+	st.Expect(t, errors.Is(err, fsm.ErrUnknownEvent), false)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+
+	m.State = statePending
+	st.Expect(t, m.Fire("cancel"), nil)
+	st.Expect(t, m.State.ID(), stateCancelled.ID())
+
+	st.Expect(t, errors.Is(m.Fire("unknown"), fsm.ErrUnknownEvent), true)
+}
+
+func TestFire(t *testing.T) {
+	type orderState string
+
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	rules.AddEvent("start", fsm.NewTransition(statePending, stateStarted))
+
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithRules(&rules))
+	state, err := fsm.Fire[orderState](m, "start")
+	st.Expect(t, err, nil)
+	st.Expect(t, state, orderState("started"))
+
+	// not registered from started, the state is unchanged
+	state, err = fsm.Fire[orderState](m, "start")
+	st.Expect(t, errors.Is(err, fsm.ErrUnknownEvent), true)
+	st.Expect(t, state, orderState("started"))
+
+	current, err := m.FireState("start")
+	st.Expect(t, errors.Is(err, fsm.ErrUnknownEvent), true)
+	st.Expect(t, current.ID(), stateStarted.ID())
+}
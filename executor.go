@@ -0,0 +1,93 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+)
+
+var (
+	// ErrExecutorClosed is returned when running guards on a closed Executor
+	ErrExecutorClosed = errors.New("executor closed")
+)
+
+// Executor is a pool of goroutines running the guards of machines
+// evaluating them concurrently, see WithExecutor. It lets busy machines
+// reuse goroutines rather than spawning one per guard, and bounds the
+// number of guards running at once across all the machines sharing it.
+type Executor struct {
+	workers int
+	work    chan func()
+	done    chan struct{}
+	start   sync.Once
+	stop    sync.Once
+}
+
+// NewExecutor creates an executor of the given number of goroutines,
+// GOMAXPROCS when workers <= 0. The goroutines are started on first use.
+func NewExecutor(workers int) *Executor {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &Executor{
+		workers: workers,
+		work:    make(chan func()),
+		done:    make(chan struct{}),
+	}
+}
+
+// Close stops the goroutines of the executor once they finished the guard
+// they are running, guards run afterwards fail with ErrExecutorClosed
+func (e *Executor) Close() {
+	e.stop.Do(func() { close(e.done) })
+}
+
+// submit runs f on one of the goroutines, waiting for one to be available
+// until ctx is done
+func (e *Executor) submit(ctx context.Context, f func()) error {
+	e.start.Do(func() {
+		for i := 0; i < e.workers; i++ {
+			go e.loop()
+		}
+	})
+
+	select {
+	case <-e.done:
+		return ErrExecutorClosed
+	default:
+	}
+	select {
+	case e.work <- f:
+		return nil
+	case <-e.done:
+		return ErrExecutorClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loop runs the submitted functions until the executor is closed
+func (e *Executor) loop() {
+	for {
+		select {
+		case f := <-e.work:
+			f()
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// WithExecutor makes the machine run the guards of a transition
+// concurrently on the goroutines of e, which can be shared by many
+// machines. Like with WithGuardConcurrency all guards must pass, and
+// WithGuardConcurrency can further limit how many guards of a transition
+// run at once. Guards waiting for a goroutine are abandoned once the
+// context of the transition is done.
+func WithExecutor(e *Executor) func(*Machine) {
+	return func(m *Machine) {
+		m.eval.parallel = true
+		m.eval.exec = e
+	}
+}
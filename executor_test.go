@@ -0,0 +1,100 @@
+package fsm_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestMachineExecutor(t *testing.T) {
+	e := fsm.NewExecutor(4)
+	defer e.Close()
+
+	var max int32
+	rules := concurrencyRules(20, &max)
+	m1 := fsm.New(fsm.WithExecutor(e), fsm.WithInitialState(statePending))
+	m1.Rules = &rules
+	m2 := m1.For(statePending)
+
+	done := make(chan error)
+	go func() { done <- m1.Transition(stateStarted) }()
+	st.Expect(t, m2.Transition(stateStarted), nil)
+	st.Expect(t, <-done, nil)
+	st.Assert(t, max <= 4, true)
+	st.Assert(t, max > 1, true)
+}
+
+func TestMachineExecutorCancel(t *testing.T) {
+	e := fsm.NewExecutor(1)
+	defer e.Close()
+
+	release := make(chan struct{})
+	rules := fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		<-release
+		return nil
+	})
+	busy := fsm.New(fsm.WithExecutor(e), fsm.WithInitialState(statePending))
+	busy.Rules = &rules
+	go busy.Transition(stateStarted)
+	defer close(release)
+
+	// the only goroutine of the executor is busy, the queued guard is
+	// abandoned once the context is done
+	m := busy.For(statePending)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	st.Expect(t, m.TransitionCtx(ctx, stateStarted), context.DeadlineExceeded)
+	st.Expect(t, m.Current(), statePending)
+}
+
+func TestMachineExecutorClosed(t *testing.T) {
+	e := fsm.NewExecutor(0)
+	e.Close()
+	e.Close()
+
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	m := fsm.New(fsm.WithExecutor(e), fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	st.Expect(t, m.Transition(stateStarted), fsm.ErrExecutorClosed)
+}
+
+// BenchmarkMachineSpawnGuards and BenchmarkMachineExecutorGuards compare
+// spawning goroutines per guard to reusing the ones of an executor
+func BenchmarkMachineSpawnGuards(b *testing.B) {
+	benchmarkConcurrentGuards(b, fsm.WithGuardConcurrency(0))
+}
+
+func BenchmarkMachineExecutorGuards(b *testing.B) {
+	e := fsm.NewExecutor(0)
+	defer e.Close()
+	benchmarkConcurrentGuards(b, fsm.WithExecutor(e))
+}
+
+func benchmarkConcurrentGuards(b *testing.B, opt func(*fsm.Machine)) {
+	var calls int32
+	rules := fsm.Ruleset{}
+	for i := 0; i < 10; i++ {
+		rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		})
+	}
+	base := fsm.New(opt)
+	base.Rules = &rules
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		m := base.For(statePending)
+		for pb.Next() {
+			m.State = statePending
+			m.Transition(stateStarted)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package fsm
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ToDOT renders the ruleset as a Graphviz digraph, with one node per state
+// and one edge per transition. Edges protected by more than the default
+// guard are labeled with their number of guards, followed by the metadata
+// of the transition (see SetMeta). The output is sorted so it can be
+// diffed.
+func (r *Ruleset) ToDOT() string {
+	ts := r.sortedTransitions()
+
+	nodes := r.States()
+	for _, t := range ts {
+		if t.O == AnyState.ID() {
+			nodes = append([]ID{AnyState.ID()}, nodes...)
+			break
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph fsm {\n")
+	for _, id := range nodes {
+		fmt.Fprintf(&b, "\t%q;\n", fmt.Sprint(id))
+	}
+	for _, t := range ts {
+		fmt.Fprintf(&b, "\t%q -> %q", fmt.Sprint(t.O), fmt.Sprint(t.E))
+		var label []string
+		if n := len(r.rules[t]); n > 1 {
+			label = append(label, fmt.Sprintf("%d guards", n))
+		}
+		label = append(label, r.metaPairs(t)...)
+		if len(label) > 0 {
+			fmt.Fprintf(&b, " [label=%q]", strings.Join(label, "\n"))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// ToMermaid renders the ruleset as a Mermaid stateDiagram-v2, with one line
+// per transition, sorted so generated documents don't churn. When initial
+// isn't nil it is marked as the starting state. States whose name isn't an
+// identifier are declared with an alias, AnyState being rendered as a state
+// named "*". Aliases start with an underscore so they can't clash with the
+// other states. Transitions having metadata (see SetMeta) are labeled with
+// it.
+func (r *Ruleset) ToMermaid(initial IDer) string {
+	var decls strings.Builder
+	aliases := map[ID]string{}
+	n := 0
+	name := func(id ID) string {
+		s := fmt.Sprint(id)
+		if id != AnyState.ID() && mermaidIdentifier(s) {
+			return s
+		}
+		if alias, ok := aliases[id]; ok {
+			return alias
+		}
+		alias := "_any"
+		if id == AnyState.ID() {
+			s = "*"
+		} else {
+			alias = fmt.Sprintf("_s%d", n)
+			n++
+		}
+		aliases[id] = alias
+		fmt.Fprintf(&decls, "\tstate \"%s\" as %s\n", strings.ReplaceAll(s, `"`, "#quot;"), alias)
+		return alias
+	}
+
+	var lines strings.Builder
+	if initial != nil {
+		fmt.Fprintf(&lines, "\t[*] --> %s\n", name(initial.ID()))
+	}
+	for _, t := range r.sortedTransitions() {
+		fmt.Fprintf(&lines, "\t%s --> %s", name(t.O), name(t.E))
+		if meta := r.metaPairs(t); len(meta) > 0 {
+			fmt.Fprintf(&lines, " : %s", strings.Join(meta, ", "))
+		}
+		lines.WriteString("\n")
+	}
+	return "stateDiagram-v2\n" + decls.String() + lines.String()
+}
+
+// mermaidIdentifier reports whether s can be used as is as a state of a
+// Mermaid diagram: a letter followed by letters, digits and underscores
+func mermaidIdentifier(s string) bool {
+	for i, c := range s {
+		if !unicode.IsLetter(c) && (i == 0 || c != '_' && !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// MachineDescriptor is a structured description of a ruleset for external
+// tooling, see Ruleset.Describe. States are given by the string of their
+// ID, AnyState being "*".
+type MachineDescriptor struct {
+	States      []string               `json:"states"`
+	Transitions []TransitionDescriptor `json:"transitions"`
+}
+
+// TransitionDescriptor describes a transition of a MachineDescriptor,
+// Guards being the number of guards of its rule, including the default one,
+// Meta its metadata, see SetMeta, and Disabled whether it is disabled, see
+// Ruleset.Disable
+type TransitionDescriptor struct {
+	From     string            `json:"from"`
+	To       string            `json:"to"`
+	Guards   int               `json:"guards"`
+	Names    []string          `json:"names,omitempty"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	Disabled bool              `json:"disabled,omitempty"`
+}
+
+// Describe returns a description of the ruleset which marshals to JSON,
+// sorted like ToDOT. Names lists the names of the named guards of a
+// transition, see AddNamedRule. Unlike ToDOT and ToMermaid it is meant to be
+// parsed, e.g. by a frontend rendering the machine.
+func (r *Ruleset) Describe() MachineDescriptor {
+	d := MachineDescriptor{States: []string{}, Transitions: []TransitionDescriptor{}}
+	for _, id := range r.States() {
+		d.States = append(d.States, fmt.Sprint(id))
+	}
+	for _, t := range r.sortedTransitions() {
+		td := TransitionDescriptor{From: fmt.Sprint(t.O), To: fmt.Sprint(t.E), Guards: len(r.rules[t]), Meta: copyMeta(r.meta[t]), Disabled: r.disabled[t]}
+		for _, guard := range r.rules[t] {
+			if guard.name != "" {
+				td.Names = append(td.Names, guard.name)
+			}
+		}
+		d.Transitions = append(d.Transitions, td)
+	}
+	return d
+}
@@ -0,0 +1,170 @@
+package fsm_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestRulesetToDOT(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(stateStarted, stateFinished),
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(fsm.AnyState, fsm.String("cancelled")),
+	)
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return nil
+	})
+
+	st.Expect(t, rules.ToDOT(), `digraph fsm {
+	"*";
+	"cancelled";
+	"finished";
+	"pending";
+	"started";
+	"*" -> "cancelled";
+	"pending" -> "started";
+	"started" -> "finished" [label="2 guards"];
+}
+`)
+	st.Expect(t, (&fsm.Ruleset{}).ToDOT(), "digraph fsm {\n}\n")
+}
+
+func TestRulesetToMermaid(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(stateStarted, stateFinished),
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(fsm.AnyState, fsm.String("cancelled")),
+	)
+
+	st.Expect(t, rules.ToMermaid(statePending), `stateDiagram-v2
+	state "*" as _any
+	[*] --> pending
+	_any --> cancelled
+	pending --> started
+	started --> finished
+`)
+
+	// names which aren't identifiers are aliased, without clashing with a
+	// state named like the alias of AnyState
+	rules.AddTransition(fsm.NewTransition(fsm.String("any"), fsm.String("on hold")))
+	rules.AddTransition(fsm.NewTransition(fsm.String(`say "hi"`), fsm.String("on hold")))
+	st.Expect(t, rules.ToMermaid(nil), `stateDiagram-v2
+	state "*" as _any
+	state "on hold" as _s0
+	state "say #quot;hi#quot;" as _s1
+	_any --> cancelled
+	any --> _s0
+	pending --> started
+	_s1 --> _s0
+	started --> finished
+`)
+	rules.RemoveRule(fsm.NewTransition(fsm.String("any"), fsm.String("on hold")))
+	rules.RemoveRule(fsm.NewTransition(fsm.String(`say "hi"`), fsm.String("on hold")))
+
+	rules.RemoveRule(fsm.NewTransition(fsm.AnyState, fsm.String("cancelled")))
+	st.Expect(t, rules.ToMermaid(nil), `stateDiagram-v2
+	pending --> started
+	started --> finished
+`)
+}
+
+func TestRulesetMeta(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+	)
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return nil
+	})
+	meta := map[string]string{"label": "Start", "role": "admin"}
+	rules.SetMeta(fsm.NewTransition(statePending, stateStarted), meta)
+	rules.SetMeta(fsm.NewTransition(stateStarted, stateFinished), map[string]string{"method": "POST"})
+	meta["role"] = "nobody"
+
+	st.Expect(t, rules.Meta(fsm.NewTransition(statePending, stateStarted)), map[string]string{"label": "Start", "role": "admin"})
+	st.Expect(t, rules.Meta(fsm.NewTransition(stateFinished, statePending)), map[string]string(nil))
+
+	clone := rules.Clone()
+	rules.SetMeta(fsm.NewTransition(statePending, stateStarted), nil)
+	st.Expect(t, rules.Meta(fsm.NewTransition(statePending, stateStarted)), map[string]string(nil))
+
+	d := clone.Describe()
+	st.Expect(t, d.Transitions[0].Meta, map[string]string{"label": "Start", "role": "admin"})
+	st.Expect(t, d.Transitions[1].Meta, map[string]string{"method": "POST"})
+	st.Expect(t, clone.ToDOT(), `digraph fsm {
+	"finished";
+	"pending";
+	"started";
+	"pending" -> "started" [label="label=Start\nrole=admin"];
+	"started" -> "finished" [label="2 guards\nmethod=POST"];
+}
+`)
+	st.Expect(t, clone.ToMermaid(nil), `stateDiagram-v2
+	pending --> started : label=Start, role=admin
+	started --> finished : method=POST
+`)
+
+	clone.RemoveRule(fsm.NewTransition(statePending, stateStarted))
+	st.Expect(t, clone.Meta(fsm.NewTransition(statePending, stateStarted)), map[string]string(nil))
+}
+
+func TestRulesetDescribe(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(stateStarted, stateFinished),
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(fsm.AnyState, fsm.String("cancelled")),
+	)
+	rules.AddNamedRule(fsm.NewTransition(stateStarted, stateFinished), "delivered", func(start *fsm.State, goal *fsm.State) error {
+		return nil
+	})
+
+	data, err := json.Marshal(rules.Describe())
+	st.Assert(t, err, nil)
+	st.Expect(t, string(data), `{"states":["cancelled","finished","pending","started"],"transitions":[`+
+		`{"from":"*","to":"cancelled","guards":1},`+
+		`{"from":"pending","to":"started","guards":1},`+
+		`{"from":"started","to":"finished","guards":2,"names":["delivered"]}]}`)
+
+	data, err = json.Marshal((&fsm.Ruleset{}).Describe())
+	st.Assert(t, err, nil)
+	st.Expect(t, string(data), `{"states":[],"transitions":[]}`)
+}
+
+func TestRulesetPermittedView(t *testing.T) {
+	cancelled := fsm.NewState(fsm.String("cancelled"))
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(statePending, stateFinished),
+		fsm.NewTransition(stateStarted, stateFinished),
+		fsm.NewTransition(fsm.AnyState, cancelled),
+	)
+	rules.AddRule(fsm.NewTransition(statePending, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+	rules.SetMeta(fsm.NewTransition(statePending, stateStarted), map[string]string{"label": "start"})
+
+	view := rules.PermittedView(&statePending)
+	st.Expect(t, view.HasRule(fsm.NewTransition(statePending, stateStarted)), true)
+	st.Expect(t, view.HasRule(fsm.NewTransition(statePending, cancelled)), true)
+	st.Expect(t, view.HasRule(fsm.NewTransition(statePending, stateFinished)), false)
+	st.Expect(t, view.HasRule(fsm.NewTransition(stateStarted, stateFinished)), false)
+	st.Expect(t, view.ToMermaid(nil), `stateDiagram-v2
+	pending --> cancelled
+	pending --> started : label=start
+`)
+
+	// the view is a copy
+	view.RemoveRule(fsm.NewTransition(statePending, stateStarted))
+	st.Expect(t, rules.HasRule(fsm.NewTransition(statePending, stateStarted)), true)
+
+	// the view permits the transitions it has from a child
+	validating := fsm.NewState(fsm.String("validating"))
+	rules.SetParent(validating, stateStarted)
+	view = rules.PermittedView(&validating)
+	st.Expect(t, view.HasRule(fsm.NewTransition(validating, stateFinished)), true)
+	st.Expect(t, view.Permitted(&validating, &stateFinished), nil)
+	st.Expect(t, view.Permitted(&validating, &cancelled), nil)
+}
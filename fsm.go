@@ -1,25 +1,117 @@
 package fsm
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
 )
 
 // Guard provides protection against transitioning to the goal State.
-// Returning an error if the transition is not permitted
+// Returning an error if the transition is not permitted, a nil error
+// means permitted. The error is kept as is, wrapped in a TransitionError,
+// so callers can tell a denial from an operational failure with errors.Is
+// or errors.As.
+// Each guard is given its own copy of the start and goal states, replacing
+// them affects neither the other guards nor the machine. The copies are
+// shallow: data carried by the states (State.I) is shared unless it
+// implements Cloner, in which case every guard gets a deep copy. Guards
+// must not change shared data, e.g. behind a pointer, which would change
+// the machine (even its current ID) and race with the guards running
+// concurrently. Guards must not transition the machine running
+// them, which is locked and would deadlock; changes depending on the
+// transition belong in callbacks, see OnEnter and OnExit.
 type Guard func(start *State, goal *State) error
 
+// GuardCtx is a Guard also receiving the context of the transition attempt,
+// guards doing slow work (e.g. calling other services) should give up once
+// the context is done.
+type GuardCtx func(ctx context.Context, start *State, goal *State) error
+
+// Ctx adapts g to a GuardCtx ignoring the context, so it can be
+// mixed with context aware guards
+func (g Guard) Ctx() GuardCtx {
+	return func(ctx context.Context, start *State, goal *State) error {
+		return g(start, goal)
+	}
+}
+
 const (
-	errTransitionFormat  = "Cannot transition from %s to %s"
-	errNoRulesFormat     = "No rules found for %s to %s"
-	errGuardFailedFormat = "Guard failed from %s to %s: %s"
+	errTransitionFormat   = "Cannot transition from %s to %s"
+	errNoRulesFormat      = "No rules found for %s to %s"
+	errGuardFailedFormat  = "Guard failed from %s to %s: %s"
+	errNamedGuardFormat   = "Guard %s failed from %s to %s: %s"
+	errMustFormat         = errTransitionFormat + ": %w"
+	errDuplicateFormat    = "%w %s"
+	errPathFormat         = "Path step %d failed: %s"
+	errUnknownStateFormat = "%w %s"
+	errPanicFormat        = "%w: %v"
 )
 
 var (
 	// ErrInvalidTransition describes the errors when doing an invalid transition
 	ErrInvalidTransition = errors.New("invalid transition")
+	// ErrGuardTimeout is returned when the guards of a transition took
+	// longer than the machine's guard timeout, see WithGuardTimeout
+	ErrGuardTimeout = errors.New("guard timeout")
+	// ErrDuplicateTransition is returned by AddTransitionStrict when the
+	// transition already exists
+	ErrDuplicateTransition = errors.New("duplicate transition")
+	// ErrUnknownState is returned by machines using WithStrictStates when
+	// the goal isn't used by any transition, and for rules using states
+	// which aren't registered, see RegisterStates
+	ErrUnknownState = errors.New("unknown state")
+	// ErrGuardPanicked is wrapped by the error of a guard which panicked,
+	// which also carries the recovered value
+	ErrGuardPanicked = errors.New("guard panicked")
+	// ErrNoRules is returned when using a machine without rules
+	ErrNoRules = errors.New("machine has no rules")
+	// ErrNoState is returned when using a machine without state,
+	// or transitioning to the zero State
+	ErrNoState = errors.New("machine has no state")
 )
 
+// TransitionError is returned when a transition is denied. It carries the
+// attempted Transition and, when a guard rejected it, the index of that
+// guard within the rule and the error it returned.
+// errors.Is(err, ErrInvalidTransition) reports true for any TransitionError.
+type TransitionError struct {
+	Transition Transition
+	// Guard is the index of the failing guard, -1 if no rules were found
+	// or the transition is disabled
+	Guard int
+	// Name is the name of the failing guard, empty if it has none (see
+	// AddNamedRule), in which case it is identified by its index
+	Name string
+	// Err is the error returned by the failing guard, nil if no rules were
+	// found and ErrDisabled if the transition is disabled
+	Err error
+}
+
+// Error implements the error interface
+func (e *TransitionError) Error() string {
+	if e.Guard < 0 && e.Err == ErrDisabled {
+		return fmt.Sprintf(errDisabledFormat, e.Transition.Origin(), e.Transition.Exit())
+	}
+	if e.Guard < 0 {
+		return fmt.Sprintf(errNoRulesFormat, e.Transition.Origin(), e.Transition.Exit())
+	}
+	if e.Name != "" {
+		return fmt.Sprintf(errNamedGuardFormat, e.Name, e.Transition.Origin(), e.Transition.Exit(), e.Err.Error())
+	}
+	return fmt.Sprintf(errGuardFailedFormat, e.Transition.Origin(), e.Transition.Exit(), e.Err.Error())
+}
+
+// Unwrap returns the error of the failing guard
+func (e *TransitionError) Unwrap() error { return e.Err }
+
+// Is lets errors.Is match ErrInvalidTransition
+func (e *TransitionError) Is(target error) bool { return target == ErrInvalidTransition }
+
 // Transition is the change between States
 type Transition interface {
 	Origin() ID
@@ -39,6 +131,9 @@ func (t T) Origin() ID { return t.O }
 // Exit returns the ending state
 func (t T) Exit() ID { return t.E }
 
+// String renders the transition as "origin -> exit"
+func (t T) String() string { return fmt.Sprintf("%v -> %v", t.O, t.E) }
+
 // NewTransition let's you create a new transition and apply some rules
 func NewTransition(i1 IDer, i2 IDer) T {
 	return T{
@@ -48,85 +143,1476 @@ func NewTransition(i1 IDer, i2 IDer) T {
 }
 
 // Ruleset stores the rules for the state machine.
-type Ruleset map[ID][]Guard
+// The zero value is an empty ruleset ready to be used. Copies of a Ruleset
+// share the rules it had, but not reliably what is added afterwards (e.g.
+// rules added to an empty copy or global guards): share a *Ruleset
+// instead, see WithRules. Ruleset used to be a map, see HasRule,
+// GuardCount, Transitions and RemoveRule for what indexing did.
+type Ruleset struct {
+	rules   map[T][]guardEntry
+	global  []guardEntry
+	origins map[ID][]guardEntry
+	exits   map[ID][]guardEntry
+	events  map[Event]map[ID]ID
+	// entry invariants by state, see AddEntryInvariant
+	invariants map[ID][]guardEntry
+	// predicate rules by origin, see AddRuleFunc
+	matches map[ID][]matchRule
+	// priorities of the transitions, see AddTransitionP
+	priorities map[T]int
+	// metadata of the transitions, see SetMeta
+	meta map[T]map[string]string
+	// weights of the transitions, see SetWeight
+	weights map[T]float64
+	// transitions turned off, see Disable
+	disabled map[T]bool
+	// cascades attempted once states are entered, see AddCascade
+	cascades map[ID][]State
+	// registry of the valid states, see RegisterStates, and err the first
+	// rule rejected because of it
+	registry map[ID]bool
+	err      error
+	// parents of the states, see SetParent
+	parents map[ID]ID
+	// terminal states, see MarkTerminal
+	terminal map[ID]bool
+}
+
+// guardEntry is a guard as stored by a Ruleset, with its optional name
+// and its tier, see AddRuleTiered
+type guardEntry struct {
+	// id identifies the guard function, see funcID
+	id    uintptr
+	name  string
+	tier  int
+	check GuardCtx
+	// cacheable guards can be cached, see AddRuleCacheable
+	cacheable bool
+	// shared guards give the same result whatever the goal, see
+	// AddSharedRule
+	shared bool
+}
+
+// newGuard returns the entry of a guard added to a ruleset
+func newGuard(guard Guard) guardEntry {
+	return guardEntry{id: funcID(guard), check: guard.Ctx()}
+}
+
+// newGuardCtx is newGuard for a context aware guard
+func newGuardCtx(guard GuardCtx) guardEntry {
+	return guardEntry{id: funcID(guard), check: guard}
+}
+
+// funcID identifies a function value: the same function or closure given
+// twice has the same id, while two closures created by the same function
+// literal don't. It relies on func values being pointers to the closure.
+func funcID[F any](f F) uintptr {
+	return *(*uintptr)(unsafe.Pointer(&f))
+}
+
+// run runs the guard, turning a panic into an error wrapping
+// ErrGuardPanicked so that a buggy guard only denies the transition
+func (e guardEntry) run(ctx context.Context, start *State, goal *State) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf(errPanicFormat, ErrGuardPanicked, v)
+		}
+	}()
+	return e.check(ctx, start, goal)
+}
+
+// String lists the transitions, sorted, with their number of guards
+// e.g. "[pending -> started (1 guard), started -> finished (2 guards)]".
+// It has a value receiver so that printing a Ruleset value uses it too.
+func (r Ruleset) String() string {
+	ts := r.sortedTransitions()
+	parts := make([]string, len(ts))
+	for i, t := range ts {
+		n := len(r.rules[t])
+		plural := "s"
+		if n == 1 {
+			plural = ""
+		}
+		parts[i] = fmt.Sprintf("%s (%d guard%s)", t, n, plural)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// key returns the key under which the rules of t are stored
+func key(t Transition) T {
+	return T{t.Origin(), t.Exit()}
+}
+
+// AddRule adds Guards for the given Transition. Giving no guard adds a
+// rule without guards, which opens no transition by default: what it
+// means is up to the EmptyGuardPolicy, see WithEmptyGuardPolicy. Use
+// AddTransition for transitions only checking their origin.
+func (r *Ruleset) AddRule(t Transition, guards ...Guard) {
+	entries := make([]guardEntry, len(guards))
+	for i, guard := range guards {
+		entries[i] = newGuard(guard)
+	}
+	r.addGuards(key(t), entries...)
+}
+
+// AddRuleCtx adds context aware Guards for the given Transition, see AddRule
+func (r *Ruleset) AddRuleCtx(t Transition, guards ...GuardCtx) {
+	entries := make([]guardEntry, len(guards))
+	for i, guard := range guards {
+		entries[i] = newGuardCtx(guard)
+	}
+	r.addGuards(key(t), entries...)
+}
+
+// AddRuleAny adds Guards for the given Transition of which any has to
+// pass, e.g. being the owner, an admin or having a share link: they are run
+// in order until one passes, and the error of the last one is returned if
+// none does, see Or. They count as a single guard of the rule, which the
+// other guards of the transition still have to pass unless one of them
+// returns Allow.
+func (r *Ruleset) AddRuleAny(t Transition, guards ...Guard) {
+	if len(guards) == 0 {
+		r.addGuards(key(t))
+		return
+	}
+	r.addGuards(key(t), newGuard(Or(guards...)))
+}
+
+// AddRuleTiered adds Guards for the given Transition run in a tier: the
+// guards of a transition are run tier by tier, from the lowest, and the
+// guards of a tier only once all the guards of the lower tiers passed.
+// This lets cheap guards (e.g. is the user logged in) keep expensive ones
+// (e.g. calling a billing API) from running, including when the guards
+// are run concurrently (see WithGuardConcurrency), only the guards of a
+// same tier being run at once. Guards added without tier, including the
+// origin and global guards, are in tier 0.
+func (r *Ruleset) AddRuleTiered(t Transition, tier int, guards ...Guard) {
+	entries := make([]guardEntry, len(guards))
+	for i, guard := range guards {
+		entries[i] = newGuard(guard)
+		entries[i].tier = tier
+	}
+	r.addGuards(key(t), entries...)
+}
+
+// AddNamedRule adds Guards for the given Transition under a name, reported
+// by the TransitionError returned when one of them fails so it can be
+// told apart from the others, e.g. in logs or metrics
+func (r *Ruleset) AddNamedRule(t Transition, name string, guards ...Guard) {
+	entries := make([]guardEntry, len(guards))
+	for i, guard := range guards {
+		entries[i] = newGuard(guard)
+		entries[i].name = name
+	}
+	r.addGuards(key(t), entries...)
+}
 
-// AddRule adds Guards for the given Transition
-func (r Ruleset) AddRule(t Transition, guards ...Guard) {
+// addGuards appends guards to the rule of t, creating it if needed, unless
+// t uses a state which isn't registered
+func (r *Ruleset) addGuards(t T, guards ...guardEntry) {
+	err := r.checkRegistered(t)
+	if err == nil {
+		err = r.checkTerminal(t.O)
+	}
+	if err != nil {
+		if r.err == nil {
+			r.err = err
+		}
+		return
+	}
+	if r.rules == nil {
+		r.rules = map[T][]guardEntry{}
+	}
+	r.rules[t] = append(r.rules[t][:len(r.rules[t]):len(r.rules[t])], guards...)
+}
+
+// AddGlobalGuard adds guards run for every transition, in addition to the
+// guards of its rule: a transition is permitted only if both the guards of
+// its rule and the global guards pass. Global guards are run last and
+// don't make a transition without rule permitted.
+func (r *Ruleset) AddGlobalGuard(guards ...Guard) {
+	for _, guard := range guards {
+		r.global = append(r.global, newGuard(guard))
+	}
+}
+
+// AddOriginGuard adds guards run for every transition leaving the origin,
+// whatever its exit. Like global guards they come in addition to the guards
+// of the rule: all of the rule, origin, exit and global guards must pass,
+// and they are run in that order.
+func (r *Ruleset) AddOriginGuard(origin IDer, guards ...Guard) {
+	if r.origins == nil {
+		r.origins = map[ID][]guardEntry{}
+	}
 	for _, guard := range guards {
-		r[t] = append(r[t], guard)
+		r.origins[origin.ID()] = append(r.origins[origin.ID()], newGuard(guard))
+	}
+}
+
+// mergeGuards appends the guards of src to the ones of dst for the same
+// ID, allocating dst if needed. Slices are copied so dst and src never
+// share backing arrays.
+func mergeGuards(dst, src map[ID][]guardEntry) map[ID][]guardEntry {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[ID][]guardEntry, len(src))
+	}
+	for id, guards := range src {
+		dst[id] = append(dst[id][:len(dst[id]):len(dst[id])], guards...)
+	}
+	return dst
+}
+
+// RemoveRule removes the transition and all of its guards,
+// removing a transition which doesn't exist does nothing
+func (r *Ruleset) RemoveRule(t Transition) {
+	delete(r.rules, key(t))
+	delete(r.priorities, key(t))
+	delete(r.meta, key(t))
+	delete(r.weights, key(t))
+	delete(r.disabled, key(t))
+}
+
+// RemoveGuard removes the guard at the given index for the transition,
+// keeping the others. Removing the last guard removes the transition.
+// Out of range indexes and unknown transitions are ignored.
+func (r *Ruleset) RemoveGuard(t Transition, index int) {
+	guards := r.rules[key(t)]
+	if index < 0 || index >= len(guards) {
+		return
+	}
+	if len(guards) == 1 {
+		r.RemoveRule(t)
+		return
 	}
+
+	kept := make([]guardEntry, 0, len(guards)-1)
+	kept = append(kept, guards[:index]...)
+	r.rules[key(t)] = append(kept, guards[index+1:]...)
 }
 
 // AddTransition adds a transition with a default rule
-func (r Ruleset) AddTransition(t Transition) {
-	r.AddRule(t, func(start *State, goal *State) error {
-		if start.ID() != t.Origin() {
-			return fmt.Errorf(errTransitionFormat, start.ID(), goal.ID())
+func (r *Ruleset) AddTransition(t Transition) {
+	r.AddRuleCtx(t, originGuard(t))
+}
+
+// originGuard is the default guard of t, denying the transition unless it
+// starts from its origin, a child of it or its origin is AnyState
+func originGuard(t Transition) GuardCtx {
+	return func(ctx context.Context, start *State, goal *State) error {
+		if start.ID() != t.Origin() && t.Origin() != AnyState.ID() && !descends(ctx, start.ID(), t.Origin()) {
+			return Deny(fmt.Sprintf(errTransitionFormat, start.ID(), goal.ID()))
 		}
 		return nil
-	})
+	}
+}
+
+// Merge adds the rules of other to r. Guards of transitions existing in both
+// are appended to the ones of r, so all of them must pass, and transitions
+// only existing in other are copied over.
+// Global, origin and exit guards, entry invariants, predicate rules and
+// cascades of other are appended to the ones of r.
+// Events, priorities, metadata, weights and parents of other are added as
+// well, replacing the ones of r for the same event and origin, transition
+// or child, and so are its terminal states and disabled transitions.
+func (r *Ruleset) Merge(other Ruleset) {
+	for id := range other.registry {
+		r.RegisterStates(IDState(id))
+	}
+	if r.err == nil {
+		r.err = other.err
+	}
+	for t, guards := range other.rules {
+		r.addGuards(t, guards...)
+	}
+	r.global = append(r.global, other.global...)
+	r.origins = mergeGuards(r.origins, other.origins)
+	r.exits = mergeGuards(r.exits, other.exits)
+	r.invariants = mergeGuards(r.invariants, other.invariants)
+	r.matches = mergeMatches(r.matches, other.matches)
+	for e, exits := range other.events {
+		for origin, exit := range exits {
+			r.AddEvent(e, T{origin, exit})
+		}
+	}
+	for t, priority := range other.priorities {
+		r.setPriority(t, priority)
+	}
+	for t, meta := range other.meta {
+		r.SetMeta(t, meta)
+	}
+	for t, w := range other.weights {
+		r.SetWeight(t, w)
+	}
+	for t := range other.disabled {
+		r.Disable(t)
+	}
+	for after, attempts := range other.cascades {
+		for _, attempt := range attempts {
+			r.AddCascade(IDState(after), attempt)
+		}
+	}
+	for child, parent := range other.parents {
+		r.SetParent(IDState(child), IDState(parent))
+	}
+	for id := range other.terminal {
+		r.MarkTerminal(IDState(id))
+	}
+}
+
+// Clone returns a deep copy of r, adding or removing rules on the copy
+// doesn't affect r and the other way around.
+func (r *Ruleset) Clone() Ruleset {
+	c := Ruleset{
+		rules:      make(map[T][]guardEntry, len(r.rules)),
+		global:     append([]guardEntry(nil), r.global...),
+		origins:    mergeGuards(nil, r.origins),
+		exits:      mergeGuards(nil, r.exits),
+		invariants: mergeGuards(nil, r.invariants),
+		matches:    mergeMatches(nil, r.matches),
+		err:        r.err,
+	}
+	for id := range r.registry {
+		c.RegisterStates(IDState(id))
+	}
+	for t, guards := range r.rules {
+		c.rules[t] = append([]guardEntry(nil), guards...)
+	}
+	for e, exits := range r.events {
+		for origin, exit := range exits {
+			c.AddEvent(e, T{origin, exit})
+		}
+	}
+	for t, priority := range r.priorities {
+		c.setPriority(t, priority)
+	}
+	for t, meta := range r.meta {
+		c.SetMeta(t, meta)
+	}
+	for t, w := range r.weights {
+		c.SetWeight(t, w)
+	}
+	for t := range r.disabled {
+		c.Disable(t)
+	}
+	for after, attempts := range r.cascades {
+		for _, attempt := range attempts {
+			c.AddCascade(IDState(after), attempt)
+		}
+	}
+	for child, parent := range r.parents {
+		c.SetParent(IDState(child), IDState(parent))
+	}
+	for id := range r.terminal {
+		c.MarkTerminal(IDState(id))
+	}
+	return c
+}
+
+// AddTransitionStrict is like AddTransition but fails with an error
+// wrapping ErrDuplicateTransition if the transition already has rules,
+// ErrTerminalState if its origin is terminal, or ErrUnknownState if it
+// uses a state which isn't registered (see RegisterStates), to catch
+// mistakes in machine definitions
+func (r *Ruleset) AddTransitionStrict(t Transition) error {
+	if err := r.checkRegistered(key(t)); err != nil {
+		return err
+	}
+	if _, ok := r.rules[key(t)]; ok {
+		return fmt.Errorf(errDuplicateFormat, ErrDuplicateTransition, key(t))
+	}
+	if err := r.checkTerminal(t.Origin()); err != nil {
+		return err
+	}
+	r.AddTransition(t)
+	return nil
 }
 
 // CreateRuleset will establish a ruleset with the provided transitions.
 // This eases initialization when storing within another structure.
 func CreateRuleset(transitions ...Transition) Ruleset {
 	r := Ruleset{}
+	r.AddTransitions(transitions...)
+	return r
+}
+
+// AddExitGuard adds guards run for every transition entering the exit,
+// whatever its origin, e.g. to only enter "published" once reviewed. It is
+// the mirror of AddOriginGuard: all of the rule, origin, exit and global
+// guards of a transition must pass, and they are run in that order.
+func (r *Ruleset) AddExitGuard(exit IDer, guards ...Guard) {
+	if r.exits == nil {
+		r.exits = map[ID][]guardEntry{}
+	}
+	for _, guard := range guards {
+		r.exits[exit.ID()] = append(r.exits[exit.ID()], newGuard(guard))
+	}
+}
 
+// AddTransitions adds the transitions with a default rule,
+// see AddTransition
+func (r *Ruleset) AddTransitions(transitions ...Transition) {
 	for _, t := range transitions {
 		r.AddTransition(t)
 	}
+}
 
+// LinearFlow creates a ruleset for a simple pipeline, with a transition
+// from every state to the next one.
+func LinearFlow(states ...IDer) Ruleset {
+	r := Ruleset{}
+	for i := 1; i < len(states); i++ {
+		r.AddTransition(NewTransition(states[i-1], states[i]))
+	}
 	return r
 }
 
-// Permitted determines if a transition is allowed.
-// This occurs in parallel.
-// NOTE: Guards are not halted if they are short-circuited for some
-// transition. They may continue running *after* the outcome is determined.
-func (r Ruleset) Permitted(start *State, goal *State) error {
+// Permitted determines if a transition is allowed, which is the case
+// only if all of its guards pass.
+// Guards are run one after the other, in the order they were added, and
+// the first one returning an error short-circuits the remaining ones.
+// This order is part of the contract, guards with side effects can rely
+// on it. No goroutine is spawned, so nothing is left running once it returns.
+// Machines can run them concurrently instead, see WithGuardConcurrency.
+// Guards of a higher tier are run after the others, see AddRuleTiered.
+// A guard which panics fails with an error wrapping ErrGuardPanicked.
+// A guard returning Allow permits the transition without running the
+// following guards, the ones before it must have passed.
+func (r *Ruleset) Permitted(start *State, goal *State) error {
+	return r.PermittedCtx(context.Background(), start, goal)
+}
+
+// PermittedCtx is like Permitted but passes ctx to the guards. Once ctx is
+// done the remaining guards are not run and ctx.Err() is returned.
+// When no rule exists for the exact transition, the rule from the closest
+// parent of the start having one (see SetParent), else the rule from
+// AnyState to the goal, else the first predicate rule of the start matching
+// the goal (see AddRuleFunc) is used if any. The origin, exit then global
+// guards are run after the guards of the rule, see AddOriginGuard,
+// AddExitGuard and AddGlobalGuard, and the entry invariants of the goal
+// last, see AddEntryInvariant.
+func (r *Ruleset) PermittedCtx(ctx context.Context, start *State, goal *State) error {
+	return r.permittedWith(ctx, evaluation{}, start, goal)
+}
+
+// evaluation configures how a Machine runs the guards of a transition
+type evaluation struct {
+	// parallel runs the guards concurrently, limit at most at once
+	// (without limit when <= 0), see WithGuardConcurrency
+	parallel bool
+	limit    int
+	// exec runs the guards when parallel, see WithExecutor
+	exec *Executor
+	// memo runs every guard function once, see WithGuardMemoization
+	memo bool
+	// empty tells what rules without guards mean, see WithEmptyGuardPolicy
+	empty EmptyGuardPolicy
+	// cache holds the results of cacheable guards, see WithGuardCache
+	cache *guardCache
+}
+
+// permittedWith is PermittedCtx running the guards as configured by e
+func (r *Ruleset) permittedWith(ctx context.Context, e evaluation, start *State, goal *State) error {
 	attempt := T{start.ID(), goal.ID()}
 
-	if guards, ok := r[attempt]; ok {
+	steps, ok := r.lookup(attempt, goal, e.empty)
+	if !ok {
+		return r.noRule(attempt)
+	}
+	invariants := r.invariantSteps(attempt.E, len(steps))
+	if e.memo {
+		steps = dedupe(steps)
+	}
+	if e.cache != nil {
+		steps = e.cache.wrap(attempt, steps)
+	}
+	ctx = r.withRuleset(ctx)
+	var err error
+	if e.parallel {
+		err = r.checkParallel(ctx, e, attempt, start, goal, steps)
+	} else {
+		err = r.check(ctx, attempt, start, goal, steps)
+	}
+	if err != nil {
+		return err
+	}
+	return r.check(ctx, attempt, start, goal, invariants)
+}
+
+// step is a guard to run for a transition, index being its position
+// across the rule, origin and global guards
+type step struct {
+	index int
+	guardEntry
+}
+
+// lookup returns the guards to run for the attempt to the goal in order:
+// the guards of its rule, then the origin, exit and global guards, sorted
+// by tier, and whether a rule exists for it, disabled rules being treated
+// as missing. Rules without guards, predicate ones included, are handled
+// according to the policy.
+func (r *Ruleset) lookup(attempt T, goal *State, policy EmptyGuardPolicy) ([]step, bool) {
+	var rule []guardEntry
+	t, ok := r.ruleFor(attempt.O, attempt.E)
+	if ok {
+		if r.disabled[t] {
+			return nil, false
+		}
+		rule = r.rules[t]
+	} else if rule, ok = r.matchFor(attempt.O, goal); ok {
+		t = attempt
+	} else {
+		return nil, false
+	}
+	if len(rule) == 0 {
+		switch policy {
+		case PolicyDeny:
+			return nil, false
+		case PolicyOriginCheck:
+			rule = []guardEntry{newGuardCtx(originGuard(t))}
+		}
+	}
 
+	var steps []step
+	tiered := false
+	for _, guards := range [][]guardEntry{rule, r.origins[attempt.O], r.exits[attempt.E], r.global} {
 		for _, guard := range guards {
-			err := guard(start, goal)
+			steps = append(steps, step{len(steps), guard})
+			tiered = tiered || guard.tier != 0
+		}
+	}
+	if tiered {
+		sort.SliceStable(steps, func(i, j int) bool { return steps[i].tier < steps[j].tier })
+	}
+	return steps, true
+}
+
+// dedupe returns the steps without the guards appearing again, as the
+// first run of a guard gives its result
+func dedupe(steps []step) []step {
+	seen := make(map[uintptr]bool, len(steps))
+	kept := steps[:0:0]
+	for _, guard := range steps {
+		if !seen[guard.id] {
+			seen[guard.id] = true
+			kept = append(kept, guard)
+		}
+	}
+	return kept
+}
+
+// PermittedDetailed is like Permitted but runs every guard, without
+// short-circuiting, and returns an error for each guard which failed,
+// in order. It returns nil if the transition is permitted, and a single
+// error with a Guard of -1 if there is no rule for it or it is disabled.
+// It is slower than
+// Permitted and meant for showing every reason a transition is blocked.
+// A guard returning Allow still stops the evaluation, only the failures
+// of the guards before it are returned.
+func (r *Ruleset) PermittedDetailed(start *State, goal *State) []*TransitionError {
+	attempt := T{start.ID(), goal.ID()}
+
+	steps, ok := r.lookup(attempt, goal, PolicyDeny)
+	if !ok {
+		return []*TransitionError{r.noRule(attempt)}
+	}
+
+	ctx := r.withRuleset(context.Background())
+	var errs []*TransitionError
+	for _, group := range [][]step{steps, r.invariantSteps(attempt.E, len(steps))} {
+		for _, guard := range group {
+			s, g := guardCopy(*start), guardCopy(*goal)
+			err := guard.run(ctx, &s, &g)
+			if errors.Is(err, Allow) {
+				break
+			}
 			if err != nil {
-				return fmt.Errorf(errGuardFailedFormat, start.ID(), goal.ID(), err.Error())
+				errs = append(errs, &TransitionError{Transition: attempt, Guard: guard.index, Name: guard.name, Err: err})
 			}
+		}
+	}
+	return errs
+}
 
-			start.id = start.ID()
-			goal.id = goal.ID()
+// PermittedAllErrors is like PermittedDetailed but joins the errors with
+// errors.Join, so that a single error lets callers see every failure, e.g.
+// with errors.Is for each downstream a guard depends on. It returns nil if
+// the transition is permitted.
+func (r *Ruleset) PermittedAllErrors(start *State, goal *State) error {
+	var errs []error
+	for _, err := range r.PermittedDetailed(start, goal) {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// checkParallel is check running up to e.limit guards at once (all of them
+// when <= 0), tier by tier, on e.exec if set. On the first failure no more guards are
+// started and the context given to the running ones is cancelled.
+func (r *Ruleset) checkParallel(ctx context.Context, e evaluation, attempt T, start *State, goal *State, steps []step) error {
+	limit := e.limit
+	if limit <= 0 || limit > len(steps) {
+		limit = len(steps)
+	}
+
+	gctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		step
+		err error
+	}
+	// Buffered so guards still running once we returned can always exit
+	results := make(chan result, len(steps))
+
+	next, running := 0, 0
+	for next < len(steps) || running > 0 {
+		// Guards of the next tier only start once the current one passed
+		if next < len(steps) && running < limit && (running == 0 || steps[next].tier == steps[next-1].tier) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			guard := steps[next]
+			s, g := guardCopy(*start), guardCopy(*goal)
+			run := func() {
+				results <- result{guard, guard.run(gctx, &s, &g)}
+			}
+			if e.exec == nil {
+				go run()
+			} else if err := e.exec.submit(ctx, run); err != nil {
+				return err
+			}
+			next++
+			running++
+			continue
 		}
-		return nil
+
+		select {
+		case res := <-results:
+			running--
+			if errors.Is(res.err, Allow) {
+				return nil
+			}
+			if res.err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return &TransitionError{Transition: attempt, Guard: res.index, Name: res.name, Err: res.err}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// check runs the guards in order, stopping at the first failure
+func (r *Ruleset) check(ctx context.Context, attempt T, start *State, goal *State, steps []step) error {
+	for _, guard := range steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s, g := guardCopy(*start), guardCopy(*goal)
+		err := guard.run(ctx, &s, &g)
+		if errors.Is(err, Allow) {
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return &TransitionError{Transition: attempt, Guard: guard.index, Name: guard.name, Err: err}
+		}
+	}
+	return nil
+}
+
+// Transition moves the state to the goal if permitted, without any Machine.
+// This lets many states share a single ruleset; it does no locking, use a
+// Machine per state for concurrent use or callbacks.
+func (r *Ruleset) Transition(state *State, goal State) error {
+	if err := r.Permitted(state, &goal); err != nil {
+		return err
+	}
+	*state = goal
+	return nil
+}
+
+// PermittedFrom returns the IDs of the states which can be reached from start,
+// running the guards of every transition leaving it, in the order of
+// Transitions. As rules only know about
+// IDs, the goal given to the guards only carries its ID, see IDState.
+// The result is sorted and never nil, and empty for terminal states.
+func (r *Ruleset) PermittedFrom(start *State) []ID {
+	return r.permittedFrom(start, func(goal State) bool {
+		return r.Permitted(start, &goal) == nil
+	})
+}
+
+// permittedFrom is PermittedFrom asking permitted whether each goal is
+func (r *Ruleset) permittedFrom(start *State, permitted func(goal State) bool) []ID {
+	seen := map[ID]bool{}
+	ids := []ID{}
+	if r.IsTerminal(start) {
+		return ids
+	}
+	for _, t := range r.sortedTransitions() {
+		if seen[t.Exit()] {
+			continue
+		}
+		if _, ok := r.ruleFor(start.ID(), t.Exit()); !ok {
+			continue
+		}
+		seen[t.Exit()] = true
+		if permitted(IDState(t.Exit())) {
+			ids = append(ids, t.Exit())
+		}
+	}
+	sortIDs(ids)
+	return ids
+}
+
+// PermittedView returns a ruleset only having the transitions currently
+// permitted from the subject, see PermittedFrom, e.g. to render what is
+// possible right now with ToDOT or ToMermaid. The transitions are given
+// from the state of the subject, even when permitted by a rule from
+// AnyState or a parent, and keep the guards and metadata of that rule,
+// the parents being kept too for the guards checking the origin.
+// The view is a copy, changing it doesn't affect r.
+func (r *Ruleset) PermittedView(subject *State) Ruleset {
+	view := Ruleset{rules: map[T][]guardEntry{}}
+	for child, parent := range r.parents {
+		view.SetParent(IDState(child), IDState(parent))
+	}
+	for _, exit := range r.PermittedFrom(subject) {
+		rule, _ := r.ruleFor(subject.ID(), exit)
+		t := T{subject.ID(), exit}
+		view.rules[t] = append([]guardEntry(nil), r.rules[rule]...)
+		view.SetMeta(t, r.meta[rule])
 	}
-	return fmt.Errorf(errNoRulesFormat, start.ID(), goal.ID())
+	return view
 }
 
 // Machine is a pairing of Rules and a State.
 // The state or rules may be changed at any time within
 // the machine's lifecycle.
+// Machine methods are safe for concurrent use: the guard check and the
+// state change of a Transition happen under a lock, so the State itself
+// doesn't need to be safe for concurrent use. Accessing the fields directly
+// while transitions are happening isn't, use Current instead.
+// A Machine must not be copied once used.
 type Machine struct {
 	Rules *Ruleset
 	State State
+
+	mu           sync.Mutex
+	onEnter      map[ID][]PayloadCallback
+	onExit       map[ID][]PayloadCallback
+	onReject     []RejectCallback
+	guardTimeout time.Duration
+	eval         evaluation
+	history      *history
+	observer     Observer
+	logger       Logger
+	// pre are the interceptors run before the guards, see WithPreTransition
+	pre []func(start State, goal State) error
+	// stats counts the attempts of the transitions, see WithStats
+	stats map[T]TransitionStats
+	// selfQuiet skips the callbacks of self transitions
+	selfQuiet bool
+	// idempotent makes transitions to the current state no-ops
+	idempotent bool
+	// strict rejects goals unknown to the rules
+	strict bool
+	// sinks tells transitions from sink states apart, see WithSinkDetection
+	sinks bool
+	// initial is the state set by WithInitialState
+	initial State
+	// err records an invalid option given to New
+	err error
+
+	subscribers []chan TransitionEvent
+	dropped     uint64
+
+	// queue holds the transitions given to Enqueue
+	queue *queue
+}
+
+// Callback is run by the Machine when it changes state, start is the state
+// being left and goal the state being entered.
+type Callback func(start State, goal State)
+
+// withPayload adapts c to a PayloadCallback ignoring the payload
+func (c Callback) withPayload() PayloadCallback {
+	return func(start State, goal State, payload interface{}) {
+		c(start, goal)
+	}
+}
+
+// OnEnter registers callbacks run right after the machine entered
+// the state s
+func (m *Machine) OnEnter(s IDer, callbacks ...Callback) {
+	for _, c := range callbacks {
+		m.OnEnterWith(s, c.withPayload())
+	}
+}
+
+// OnExit registers callbacks run right before the machine leaves
+// the state s
+func (m *Machine) OnExit(s IDer, callbacks ...Callback) {
+	for _, c := range callbacks {
+		m.OnExitWith(s, c.withPayload())
+	}
+}
+
+// AvailableTransitions returns the IDs of the states the machine
+// can currently transition to, like Ruleset.PermittedFrom but checking
+// them as CanTransition does
+func (m *Machine) AvailableTransitions() []ID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.validate() != nil {
+		return []ID{}
+	}
+	ctx := context.Background()
+	return m.Rules.permittedFrom(&m.State, func(goal State) bool {
+		return m.permits(ctx, goal) == nil
+	})
+}
+
+// CanTransition reports whether the machine could transition to the goal,
+// running the interceptors and guards as configured for the machine (see
+// WithPreTransition and WithEmptyGuardPolicy) but never changing the state
+// nor running callbacks
+func (m *Machine) CanTransition(goal State) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.precheck(goal) != nil {
+		return false
+	}
+	return m.permits(context.Background(), goal) == nil
+}
+
+// permits runs the checks of a transition to the goal without performing
+// it, the machine must be locked and the goal prechecked
+func (m *Machine) permits(ctx context.Context, goal State) error {
+	if err := m.Rules.checkTerminal(m.State.ID()); err != nil {
+		return err
+	}
+	for _, pre := range m.pre {
+		if err := pre(m.State, goal); err != nil {
+			return err
+		}
+	}
+	return m.allowed(ctx, goal)
+}
+
+// Current returns the current state of the machine
+func (m *Machine) Current() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.State
 }
 
 // Transition attempts to move the Subject to the Goal state.
+// Once the guards passed, the exit callbacks of the current state are run,
+// then the state is changed, then the enter callbacks of the goal are run.
+// Self transitions (e.g. to refresh a state) need a rule like any other
+// transition and run the callbacks too, unless WithQuietSelfTransitions is set.
+// Callbacks are run while the machine is locked and thus must not call
+// methods of the machine.
 func (m *Machine) Transition(goal State) (err error) {
-	if err = m.Rules.Permitted(&m.State, &goal); err == nil {
+	return m.TransitionCtx(context.Background(), goal)
+}
+
+// PathError is returned by TransitionPath and Replay when one of the steps
+// failed
+type PathError struct {
+	// Step is the index of the failing goal or entry
+	Step int
+	Err  error
+}
+
+// Error implements the error interface
+func (e *PathError) Error() string {
+	return fmt.Sprintf(errPathFormat, e.Step, e.Err.Error())
+}
+
+// Unwrap returns the error of the failing step
+func (e *PathError) Unwrap() error { return e.Err }
+
+// TransitionPath transitions through the goals in order, all or nothing.
+// The whole path is checked first, running the interceptors and guards of
+// every step as CanTransition does: if a step fails, nothing is performed
+// and a *PathError is returned. Otherwise the steps are performed, which
+// runs their guards again. Should a guard then give another result, the
+// machine is moved back to where it started, without running guards, its
+// history and guard cache are restored, and a *PathError is returned; the
+// callbacks, subscribers, stats and observer of the steps performed until
+// then have already been notified.
+func (m *Machine) TransitionPath(goals ...State) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ctx := context.Background()
+	if err := m.checkPath(ctx, goals); err != nil {
+		return err
+	}
+
+	start := m.State
+	var entries []HistoryEntry
+	if m.history != nil {
+		entries = append(entries, m.history.entries...)
+	}
+	for i, goal := range goals {
+		if err := m.transition(ctx, goal); err != nil {
+			m.State = start
+			m.eval.cache.clear()
+			if m.history != nil {
+				m.history.entries = entries
+			}
+			return &PathError{Step: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// checkPath checks the steps of TransitionPath, moving the machine along
+// the goals without performing the transitions and back to where it
+// started, the machine must be locked
+func (m *Machine) checkPath(ctx context.Context, goals []State) error {
+	start := m.State
+	defer func() {
+		m.State = start
+		m.eval.cache.clear()
+	}()
+
+	for i, goal := range goals {
+		err := m.precheck(goal)
+		if err == nil && !(m.idempotent && m.State.ID() == goal.ID()) {
+			err = m.permits(ctx, goal)
+		}
+		if err != nil {
+			return &PathError{Step: i, Err: err}
+		}
 		m.State = goal
+		m.eval.cache.clear()
+	}
+	return nil
+}
+
+// TryTransition is like Transition but tells denials apart from failures:
+// ok reports whether the machine moved, and err is only set for
+// operational failures. A transition without rule, or whose guards deny
+// it with an error matching ErrDenied (see Deny), or leaving a terminal
+// state (see MarkTerminal) returns false and a nil error. Other guard
+// errors, timeouts and invalid machines return an error.
+func (m *Machine) TryTransition(goal State) (ok bool, err error) {
+	err = m.Transition(goal)
+	if err == nil {
+		return true, nil
+	}
+	var terr *TransitionError
+	if errors.As(err, &terr) && (terr.Guard < 0 || errors.Is(terr.Err, ErrDenied)) {
+		return false, nil
+	}
+	if errors.Is(err, ErrTerminalState) && m.validate() == nil {
+		return false, nil
+	}
+	return false, err
+}
+
+// TransitionR is like Transition but also returns the state the machine
+// was at: the state it left if the transition succeeded, its current state
+// if it failed. Unlike reading Current beforehand, this can't race with
+// concurrent transitions.
+func (m *Machine) TransitionR(goal State) (from State, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from = m.State
+	return from, m.transition(context.Background(), goal)
+}
+
+// TransitionState is like TransitionCtx but also returns the state of the
+// machine once done: the state entered if the transition succeeded, its
+// current state if it failed. Unlike reading Current afterwards, this
+// can't race with concurrent transitions.
+func (m *Machine) TransitionState(ctx context.Context, goal State) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := m.transition(ctx, goal)
+	return m.State, err
+}
+
+// MustTransition is like Transition but panics if the transition fails,
+// the panic value is an error wrapping the one returned by Transition
+func (m *Machine) MustTransition(goal State) {
+	if start, err := m.TransitionR(goal); err != nil {
+		panic(fmt.Errorf(errMustFormat, stateID(start), stateID(goal), err))
+	}
+}
+
+// TransitionCtx is like Transition but passes ctx to the guards,
+// see Ruleset.PermittedCtx
+func (m *Machine) TransitionCtx(ctx context.Context, goal State) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.transition(ctx, goal)
+}
+
+// transition does the actual transition, the machine must be locked
+func (m *Machine) transition(ctx context.Context, goal State) (err error) {
+	if err = m.precheck(goal); err != nil {
+		return err
+	}
+	if m.idempotent && m.State.ID() == goal.ID() {
 		return nil
 	}
+	if err = m.Rules.checkTerminal(m.State.ID()); err != nil {
+		return err
+	}
+	for _, pre := range m.pre {
+		if err = pre(m.State, goal); err != nil {
+			return err
+		}
+	}
+
+	if m.observer != nil {
+		t := T{m.State.ID(), goal.ID()}
+		m.observer.TransitionAttempted(t)
+		begin := time.Now()
+		err = m.allowed(ctx, goal)
+		m.observer.GuardDuration(t, time.Since(begin))
+		if err != nil {
+			m.observer.TransitionDenied(t, err)
+		} else {
+			m.observer.TransitionAllowed(t)
+		}
+	} else {
+		err = m.allowed(ctx, goal)
+	}
+	m.count(T{m.State.ID(), goal.ID()}, err)
+	if err != nil {
+		if m.logger != nil {
+			m.logger.Log(T{m.State.ID(), goal.ID()}, err.Error())
+		}
+		m.reject(goal, err)
+		return err
+	}
+	m.enter(ctx, goal)
+	return nil
+}
+
+// enter moves the machine to a goal which was checked, running the
+// callbacks and recording the transition, the machine must be locked
+func (m *Machine) enter(ctx context.Context, goal State) {
+	start := m.State
+	quiet := m.selfQuiet && start.ID() == goal.ID()
+	if !quiet {
+		for _, c := range m.onExit[start.ID()] {
+			c(start, goal, Payload(ctx))
+		}
+	}
+	m.State = goal
+	m.eval.cache.clear()
+	m.history.record(start, goal)
+	m.publish(start, goal)
+	if !quiet {
+		for _, c := range m.onEnter[goal.ID()] {
+			c(start, goal, Payload(ctx))
+		}
+	}
+}
+
+// For returns a new machine at the given state sharing the rules of m,
+// which are never copied, and its configuration: callbacks, options and
+// observer. The history, if enabled, starts empty.
+func (m *Machine) For(state State) *Machine {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.copy(state)
+}
+
+// Cloner can be implemented by the data carried by a state (State.I) to
+// be deep copied by Machine.Clone, rather than shared with the clone, and
+// for the guards, see Guard
+type Cloner interface {
+	IDer
+	// Clone returns a copy of the data, with the same ID
+	Clone() IDer
+}
+
+// guardCopy returns the copy of s given to a guard, deep copying its data
+// if it implements Cloner
+func guardCopy(s State) State {
+	if c, ok := s.I.(Cloner); ok {
+		return NewState(c.Clone())
+	}
+	return s
+}
+
+// Clone returns a copy of m which can be experimented on without affecting
+// m: the rules are cloned (see Ruleset.Clone), the history too, and the
+// clone is at the current state of m. Callbacks, options and observer are
+// shared like with For, subscribers are not. The data carried by the state
+// is shared with m unless it implements Cloner.
+func (m *Machine) Clone() *Machine {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := m.State
+	if c, ok := state.I.(Cloner); ok {
+		state = NewState(c.Clone())
+	}
+	n := m.copy(state)
+	if m.Rules != nil {
+		rules := m.Rules.Clone()
+		n.Rules = &rules
+	}
+	if m.history != nil {
+		n.history.entries = append([]HistoryEntry(nil), m.history.entries...)
+	}
+	return n
+}
+
+// DryRun attempts the transitions to the goals in order on a copy of m,
+// running the real guards, and returns the state the copy ended at along
+// with the result of every step. A denied step leaves the copy where it
+// was for the next ones. m is left untouched: like with Clone the data of
+// the state is only copied if it implements Cloner, so guards must not
+// change it, and neither callbacks, observer, logger nor subscribers are
+// run or notified.
+func (m *Machine) DryRun(goals ...State) (State, []error) {
+	m.mu.Lock()
+	state := m.State
+	if c, ok := state.I.(Cloner); ok {
+		state = NewState(c.Clone())
+	}
+	n := m.copy(state)
+	m.mu.Unlock()
+
+	n.onEnter, n.onExit, n.onReject = nil, nil, nil
+	n.observer, n.logger = nil, nil
+	results := make([]error, len(goals))
+	for i, goal := range goals {
+		results[i] = n.Transition(goal)
+	}
+	return n.State, results
+}
+
+// copy is For for a locked machine
+func (m *Machine) copy(state State) *Machine {
+	n := &Machine{
+		Rules:        m.Rules,
+		State:        state,
+		guardTimeout: m.guardTimeout,
+		eval:         m.eval,
+		observer:     m.observer,
+		logger:       m.logger,
+		pre:          m.pre,
+		onReject:     append([]RejectCallback(nil), m.onReject...),
+		selfQuiet:    m.selfQuiet,
+		idempotent:   m.idempotent,
+		strict:       m.strict,
+		sinks:        m.sinks,
+		initial:      m.initial,
+		err:          m.err,
+	}
+	for id, callbacks := range m.onEnter {
+		n.OnEnterWith(IDState(id), callbacks...)
+	}
+	for id, callbacks := range m.onExit {
+		n.OnExitWith(IDState(id), callbacks...)
+	}
+	if m.history != nil {
+		n.history = &history{limit: m.history.limit}
+	}
+	if m.stats != nil {
+		n.stats = map[T]TransitionStats{}
+	}
+	if m.eval.cache != nil {
+		n.eval.cache = &guardCache{}
+	}
+	return n
+}
+
+// Validate checks the machine can be used, returning ErrNoRules or
+// ErrNoState if it lacks rules or a state. It is meant to be run right
+// after creating the machine, to fail fast.
+func (m *Machine) Validate() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.validate()
+}
+
+// validate is Validate for a locked machine
+func (m *Machine) validate() error {
+	if m.err != nil {
+		return m.err
+	}
+	if m.Rules == nil {
+		return ErrNoRules
+	}
+	if m.Rules.err != nil {
+		return m.Rules.err
+	}
+	if m.State.I == nil {
+		return ErrNoState
+	}
+	return nil
+}
+
+// precheck checks the machine is usable and the goal valid before
+// running any guard, the machine must be locked
+func (m *Machine) precheck(goal State) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	if goal.I == nil {
+		return ErrNoState
+	}
+	if m.strict && !m.Rules.hasState(goal.ID()) && !m.Rules.hasMatch(m.State.ID(), goal) {
+		return fmt.Errorf(errUnknownStateFormat, ErrUnknownState, goal.ID())
+	}
+	return nil
+}
+
+// EnterValidator can be implemented by the data carried by a state
+// (State.I) to veto leaving it for the goal, e.g. to enforce invariants
+// on its own fields. CanEnter is called once the guards passed, a non-nil
+// error aborting the transition and being returned.
+type EnterValidator interface {
+	CanEnter(goal State) error
+}
+
+// allowed checks the guards for the goal then the EnterValidator of the
+// current state, if any
+func (m *Machine) allowed(ctx context.Context, goal State) error {
+	if err := m.permitted(ctx, goal); err != nil {
+		if m.sinks {
+			return m.Rules.sinkError(m.State.ID(), err)
+		}
+		return err
+	}
+	if v, ok := m.State.I.(EnterValidator); ok {
+		return v.CanEnter(goal)
+	}
+	return nil
+}
+
+// permitted checks the guards for the goal, enforcing the guard timeout
+func (m *Machine) permitted(ctx context.Context, goal State) error {
+	if m.guardTimeout <= 0 {
+		return m.Rules.permittedWith(ctx, m.eval, &m.State, &goal)
+	}
 
+	tctx, cancel := context.WithTimeout(ctx, m.guardTimeout)
+	defer cancel()
+
+	// The guards get copies of the states as a guard ignoring its context
+	// keeps running after we gave up. The channel is buffered so that
+	// goroutine can always exit once the guard returns.
+	start := m.State
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Rules.permittedWith(tctx, m.eval, &start, &goal)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-tctx.Done():
+		err = tctx.Err()
+	}
+	if err == context.DeadlineExceeded && ctx.Err() == nil {
+		return ErrGuardTimeout
+	}
 	return err
 }
 
+// WithGuardTimeout sets the maximum duration the guards of a transition
+// may take, once it elapsed the transition is denied with ErrGuardTimeout.
+// Guards are given a context which is done once the timeout is reached,
+// guards ignoring it keep running in the background until they return.
+func WithGuardTimeout(d time.Duration) func(*Machine) {
+	return func(m *Machine) {
+		m.guardTimeout = d
+	}
+}
+
+// WithGuardConcurrency makes the machine run the guards of a transition
+// concurrently rather than one after the other, at most n at once (n <= 0
+// meaning no limit), to bound the load guards doing I/O put elsewhere.
+// All guards must still pass: on the first failure no more guards are
+// started and the context of the running ones is cancelled. The Guard
+// index of the returned TransitionError is the one of the first guard
+// seen failing, which may not be the lowest failing index. Likewise a guard
+// returning Allow permits the transition if it is seen before any failure.
+func WithGuardConcurrency(n int) func(*Machine) {
+	return func(m *Machine) {
+		m.eval.parallel = true
+		m.eval.limit = n
+	}
+}
+
+// WithGuardMemoization makes the machine run a guard at most once per
+// transition attempt when the same function is a guard of the rule, of the
+// origin or a global guard (e.g. both added with AddRule and
+// AddGlobalGuard), reusing its result. It is meant for expensive and
+// idempotent guards. Guards are identified by their function value: the
+// same closure given twice is the same guard, two closures created by
+// the same function literal aren't.
+func WithGuardMemoization() func(*Machine) {
+	return func(m *Machine) {
+		m.eval.memo = true
+	}
+}
+
+// WithPreTransition adds an interceptor run by every transition before its
+// guards, e.g. to deny everything during a maintenance. An error returned
+// by the interceptor aborts the transition, without running the guards nor
+// the next interceptors, and is returned as is. Interceptors are run in
+// the order they were added, with the machine locked.
+func WithPreTransition(pre func(start State, goal State) error) func(*Machine) {
+	return func(m *Machine) {
+		m.pre = append(m.pre, pre)
+	}
+}
+
+// EmptyGuardPolicy tells what a rule without guards means, e.g. one added
+// by AddRule without guards, see WithEmptyGuardPolicy
+type EmptyGuardPolicy int
+
+const (
+	// PolicyDeny considers there is no rule, the default
+	PolicyDeny EmptyGuardPolicy = iota
+	// PolicyOpen always permits the transition
+	PolicyOpen
+	// PolicyOriginCheck runs the default guard of AddTransition instead
+	PolicyOriginCheck
+)
+
+// WithEmptyGuardPolicy sets what the rules without guards mean for the
+// transitions of the machine, PolicyDeny by default. The methods of the
+// Ruleset itself, such as Permitted, always use PolicyDeny.
+func WithEmptyGuardPolicy(policy EmptyGuardPolicy) func(*Machine) {
+	return func(m *Machine) {
+		m.eval.empty = policy
+	}
+}
+
+// WithQuietSelfTransitions skips the exit and enter callbacks on self
+// transitions, from a state to the same state
+func WithQuietSelfTransitions() func(*Machine) {
+	return func(m *Machine) {
+		m.selfQuiet = true
+	}
+}
+
+// WithIdempotentNoOp makes transitions to the current state succeed without
+// doing anything: no rule is needed, guards and callbacks are not run and
+// the state, history and subscribers are left untouched. This keeps retries
+// from failing once the machine already moved.
+func WithIdempotentNoOp() func(*Machine) {
+	return func(m *Machine) {
+		m.idempotent = true
+	}
+}
+
+// WithInitialState sets the state the machine starts at. Unlike setting
+// the State field, the initial state is remembered by the machine.
+// Options are applied in order, so a later option changing the state wins.
+// Giving the zero State records ErrNoState, returned by Validate and
+// Transition, rather than panicking later on.
+func WithInitialState(s State) func(*Machine) {
+	return func(m *Machine) {
+		if s.I == nil {
+			m.err = ErrNoState
+			return
+		}
+		m.initial = s
+		m.State = s
+	}
+}
+
+// WithRules makes the machine use r, which is shared and not copied: rules
+// added to r afterwards apply to the machine, e.g.
+//
+//	rules := fsm.Ruleset{}
+//	m := fsm.New(fsm.WithRules(&rules))
+//	rules.AddTransition(fsm.NewTransition(pending, started))
+//
+// This is the same as setting the Rules field. Use Ruleset.Clone for a
+// machine not to see later changes.
+func WithRules(r *Ruleset) func(*Machine) {
+	return func(m *Machine) {
+		m.Rules = r
+	}
+}
+
+// WithStrictStates makes transitions to a goal not used by any transition
+// of the rules, nor matched by a predicate rule from the current state
+// (see AddRuleFunc), fail with ErrUnknownState, telling typos apart from
+// transitions which are not permitted
+func WithStrictStates() func(*Machine) {
+	return func(m *Machine) {
+		m.strict = true
+	}
+}
+
 // New initializes a machine
-func New(opts ...func(*Machine)) Machine {
-	var m Machine
+func New(opts ...func(*Machine)) *Machine {
+	m := &Machine{}
 
 	for _, opt := range opts {
-		opt(&m)
+		opt(m)
 	}
 
 	return m
 }
+
+// sortIDs sorts ids by their string representation, so that anything
+// listing IDs gives a stable output
+func sortIDs(ids []ID) {
+	sort.Slice(ids, func(i, j int) bool {
+		return fmt.Sprint(ids[i]) < fmt.Sprint(ids[j])
+	})
+}
+
+// Transitions returns the transitions of the ruleset sorted by origin then
+// exit, compared by their string representation. The order is stable, so
+// it can be relied on by exports and tests.
+func (r *Ruleset) Transitions() []Transition {
+	ts := r.sortedTransitions()
+	transitions := make([]Transition, len(ts))
+	for i, t := range ts {
+		transitions[i] = t
+	}
+	return transitions
+}
+
+// sortedTransitions is Transitions returning the keys of the rules
+func (r *Ruleset) sortedTransitions() []T {
+	ts := make([]T, 0, len(r.rules))
+	for t := range r.rules {
+		ts = append(ts, t)
+	}
+	sort.Slice(ts, func(i, j int) bool {
+		oi, oj := fmt.Sprint(ts[i].O), fmt.Sprint(ts[j].O)
+		if oi != oj {
+			return oi < oj
+		}
+		return fmt.Sprint(ts[i].E) < fmt.Sprint(ts[j].E)
+	})
+	return ts
+}
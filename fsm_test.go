@@ -1,8 +1,12 @@
 package fsm_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,6 +22,8 @@ var (
 	testError     = errors.New("test error")
 )
 
+type ctxKey struct{}
+
 func TestRulesetTransitions(t *testing.T) {
 	rules := fsm.CreateRuleset(
 		fsm.NewTransition(statePending, stateStarted),
@@ -44,7 +50,7 @@ func TestRulesetTransitions(t *testing.T) {
 	}
 
 	for i, ex := range examples {
-		err := rules.Permitted(ex.start, ex.goal)
+		err := rules.Permitted(&ex.start, &ex.goal)
 		out := err == nil
 		st.Expect(t, out, ex.outcome, i)
 		if out != ex.outcome {
@@ -58,19 +64,405 @@ func TestRulesetParallelGuarding(t *testing.T) {
 	rules.AddTransition(fsm.NewTransition(statePending, stateStarted))
 	rules.AddTransition(fsm.NewTransition(stateStarted, stateFinished))
 
-	// Add two failing rules, the slow should be caught first
-	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start fsm.State, goal fsm.State) error {
+	// Add two failing rules, the fast one is declared first so the slow
+	// one should never be reached
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
 		time.Sleep(1 * time.Second)
 		t.Error("Slow rule should have been short-circuited")
 		return testError
 	})
 
-	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start fsm.State, goal fsm.State) error {
+	st.Expect(t, rules.Permitted(&stateStarted, &stateFinished).Error(),
+		"Guard failed from started to finished: "+testError.Error())
+}
+
+func TestRulesetPermittedNoGoroutineLeak(t *testing.T) {
+	rules := fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		time.Sleep(10 * time.Millisecond)
 		return testError
 	})
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return nil
+	})
 
-	st.Expect(t, rules.Permitted(stateStarted, stateFinished).Error(),
-		"Guard failed from started to finished: "+testError.Error())
+	baseline := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		st.Reject(t, rules.Permitted(&stateStarted, &stateFinished), nil)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	st.Expect(t, runtime.NumGoroutine() <= baseline, true)
+}
+
+func TestRulesetEachGuardRunsOnce(t *testing.T) {
+	rules := fsm.Ruleset{}
+	calls := make([]int, 5)
+	for i := range calls {
+		i := i
+		rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+			calls[i]++
+			return nil
+		})
+	}
+
+	st.Expect(t, rules.Permitted(&stateStarted, &stateFinished), nil)
+	st.Expect(t, calls, []int{1, 1, 1, 1, 1})
+}
+
+func TestRulesetOriginGuard(t *testing.T) {
+	stateDraft := fsm.NewState(fsm.String("draft"))
+	complete := false
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(stateDraft, statePending),
+		fsm.NewTransition(stateDraft, stateFinished),
+		fsm.NewTransition(statePending, stateStarted),
+	)
+	var order []string
+	rules.AddGlobalGuard(func(start *fsm.State, goal *fsm.State) error {
+		order = append(order, "global")
+		return nil
+	})
+	rules.AddOriginGuard(stateDraft, func(start *fsm.State, goal *fsm.State) error {
+		order = append(order, "origin")
+		if !complete {
+			return testError
+		}
+		return nil
+	})
+
+	st.Expect(t, errors.Is(rules.Permitted(&stateDraft, &statePending), testError), true)
+	st.Expect(t, errors.Is(rules.Permitted(&stateDraft, &stateFinished), testError), true)
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	// origin guards don't open transitions without rule
+	st.Expect(t, rules.Permitted(&stateDraft, &stateStarted).Error(), "No rules found for draft to started")
+
+	complete = true
+	order = nil
+	st.Expect(t, rules.Permitted(&stateDraft, &statePending), nil)
+	st.Expect(t, order, []string{"origin", "global"})
+
+	clone := rules.Clone()
+	complete = false
+	st.Expect(t, errors.Is(clone.Permitted(&stateDraft, &statePending), testError), true)
+}
+
+func TestRulesetExitGuard(t *testing.T) {
+	stateDraft := fsm.NewState(fsm.String("draft"))
+	reviewed := false
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(stateDraft, stateFinished),
+		fsm.NewTransition(statePending, stateFinished),
+		fsm.NewTransition(statePending, stateStarted),
+	)
+	var order []string
+	rules.AddGlobalGuard(func(start *fsm.State, goal *fsm.State) error {
+		order = append(order, "global")
+		return nil
+	})
+	rules.AddOriginGuard(statePending, func(start *fsm.State, goal *fsm.State) error {
+		order = append(order, "origin")
+		return nil
+	})
+	rules.AddExitGuard(stateFinished, func(start *fsm.State, goal *fsm.State) error {
+		order = append(order, "exit")
+		if !reviewed {
+			return testError
+		}
+		return nil
+	})
+
+	// the exit guard blocks entry from every origin
+	st.Expect(t, errors.Is(rules.Permitted(&stateDraft, &stateFinished), testError), true)
+	st.Expect(t, errors.Is(rules.Permitted(&statePending, &stateFinished), testError), true)
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	// exit guards don't open transitions without rule
+	st.Expect(t, rules.Permitted(&stateStarted, &stateFinished).Error(), "No rules found for started to finished")
+
+	reviewed = true
+	order = nil
+	st.Expect(t, rules.Permitted(&statePending, &stateFinished), nil)
+	st.Expect(t, order, []string{"origin", "exit", "global"})
+
+	clone := rules.Clone()
+	reviewed = false
+	st.Expect(t, errors.Is(clone.Permitted(&stateDraft, &stateFinished), testError), true)
+}
+
+func TestRulesetGuardOrder(t *testing.T) {
+	rules := fsm.Ruleset{}
+	var order []int
+	record := func(i int, err error) fsm.Guard {
+		return func(start *fsm.State, goal *fsm.State) error {
+			order = append(order, i)
+			return err
+		}
+	}
+	for i := 0; i < 5; i++ {
+		rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), record(i, nil))
+	}
+	rules.AddGlobalGuard(record(5, nil), record(6, nil))
+
+	st.Expect(t, rules.Permitted(&stateStarted, &stateFinished), nil)
+	st.Expect(t, order, []int{0, 1, 2, 3, 4, 5, 6})
+
+	// a failing guard stops the evaluation, all guards must pass
+	order = nil
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), record(0, nil), record(1, testError), record(2, nil))
+	st.Reject(t, rules.Permitted(&statePending, &stateStarted), nil)
+	st.Expect(t, order, []int{0, 1})
+}
+
+func TestRulesetGuardsGetCopies(t *testing.T) {
+	rules := fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted),
+		func(start *fsm.State, goal *fsm.State) error {
+			*start = stateFinished
+			*goal = stateFinished
+			return nil
+		},
+		func(start *fsm.State, goal *fsm.State) error {
+			st.Expect(t, *start, statePending)
+			st.Expect(t, *goal, stateStarted)
+			return nil
+		},
+	)
+
+	m := fsm.Machine{State: statePending, Rules: &rules}
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, m.State, stateStarted)
+
+	concurrent := fsm.New(fsm.WithInitialState(statePending), fsm.WithGuardConcurrency(2))
+	concurrent.Rules = &rules
+	st.Expect(t, concurrent.Transition(stateStarted), nil)
+	st.Expect(t, concurrent.Current(), stateStarted)
+}
+
+func TestRulesetGuardsGetClones(t *testing.T) {
+	rules := fsm.Ruleset{}
+	hack := func(start *fsm.State, goal *fsm.State) error {
+		start.I.(*cart).status = "hacked"
+		return testError
+	}
+	paid := fsm.NewState(fsm.String("paid"))
+	rules.AddRule(fsm.NewTransition(&cart{status: "pending"}, paid), hack, hack)
+
+	// pointer data implementing Cloner is deep copied for every guard
+	for _, opts := range [][]func(*fsm.Machine){nil, {fsm.WithGuardConcurrency(2)}} {
+		m := fsm.New(append(opts, fsm.WithInitialState(fsm.NewState(&cart{status: "pending"})), fsm.WithRules(&rules))...)
+		st.Expect(t, errors.Is(m.Transition(paid), testError), true)
+		st.Expect(t, m.Current().ID(), fsm.ID("pending"))
+	}
+}
+
+func TestTransitionError(t *testing.T) {
+	rules := fsm.CreateRuleset(fsm.NewTransition(stateStarted, stateFinished))
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+
+	err := rules.Permitted(&stateStarted, &stateFinished)
+	var terr *fsm.TransitionError
+	st.Assert(t, errors.As(err, &terr), true)
+	st.Expect(t, terr.Transition, fsm.Transition(fsm.NewTransition(stateStarted, stateFinished)))
+	st.Expect(t, terr.Guard, 1)
+	st.Expect(t, errors.Is(err, testError), true)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+
+	err = rules.Permitted(&statePending, &stateFinished)
+	st.Assert(t, errors.As(err, &terr), true)
+	st.Expect(t, terr.Guard, -1)
+	st.Expect(t, terr.Err, nil)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+}
+
+func TestRulesetRemove(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+	)
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+
+	// unknown transitions and indexes are ignored
+	rules.RemoveRule(fsm.NewTransition(statePending, stateFinished))
+	rules.RemoveGuard(fsm.NewTransition(statePending, stateFinished), 0)
+	rules.RemoveGuard(fsm.NewTransition(stateStarted, stateFinished), 2)
+	st.Reject(t, rules.Permitted(&stateStarted, &stateFinished), nil)
+
+	rules.RemoveGuard(fsm.NewTransition(stateStarted, stateFinished), 1)
+	st.Expect(t, rules.Permitted(&stateStarted, &stateFinished), nil)
+
+	rules.RemoveGuard(fsm.NewTransition(stateStarted, stateFinished), 0)
+	st.Expect(t, rules.Permitted(&stateStarted, &stateFinished).Error(), "No rules found for started to finished")
+
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	rules.RemoveRule(fsm.NewTransition(statePending, stateStarted))
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted).Error(), "No rules found for pending to started")
+}
+
+func TestRulesetMerge(t *testing.T) {
+	stateCancelled := fsm.NewState(fsm.String("cancelled"))
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	other := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(statePending, stateCancelled),
+	)
+	other.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+
+	rules.Merge(other)
+
+	// overlapping guards are ANDed
+	err := rules.Permitted(&statePending, &stateStarted)
+	st.Expect(t, errors.Is(err, testError), true)
+	var terr *fsm.TransitionError
+	st.Assert(t, errors.As(err, &terr), true)
+	st.Expect(t, terr.Guard, 2)
+
+	// disjoint transitions are copied
+	st.Expect(t, rules.Permitted(&statePending, &stateCancelled), nil)
+
+	// later changes to other don't affect the merged rules
+	other.RemoveGuard(fsm.NewTransition(statePending, stateStarted), 1)
+	st.Expect(t, other.Permitted(&statePending, &stateStarted), nil)
+	st.Reject(t, rules.Permitted(&statePending, &stateStarted), nil)
+}
+
+func TestRulesetClone(t *testing.T) {
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	// leave spare capacity in the guards slice so appends could share it
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		return nil
+	})
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		return nil
+	})
+
+	clone := rules.Clone()
+	clone.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		return nil
+	})
+	clone.AddTransition(fsm.NewTransition(stateStarted, stateFinished))
+
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	st.Expect(t, errors.Is(clone.Permitted(&statePending, &stateStarted), testError), true)
+	st.Reject(t, rules.Permitted(&stateStarted, &stateFinished), nil)
+	st.Expect(t, clone.Permitted(&stateStarted, &stateFinished), nil)
+}
+
+func TestRulesetAnyState(t *testing.T) {
+	stateCancelled := fsm.NewState(fsm.String("cancelled"))
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(fsm.AnyState, stateCancelled),
+	)
+	// exact rules take precedence over the wildcard
+	rules.AddRule(fsm.NewTransition(stateFinished, stateCancelled), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+
+	st.Expect(t, rules.Permitted(&statePending, &stateCancelled), nil)
+	st.Expect(t, rules.Permitted(&stateStarted, &stateCancelled), nil)
+	st.Expect(t, errors.Is(rules.Permitted(&stateFinished, &stateCancelled), testError), true)
+	st.Reject(t, rules.Permitted(&stateStarted, &statePending), nil)
+
+	start := statePending
+	st.Expect(t, rules.PermittedFrom(&start), []fsm.ID{fsm.String("cancelled"), fsm.String("started")})
+
+	rules.AddEvent("cancel", fsm.NewTransition(fsm.AnyState, stateCancelled))
+	m := fsm.Machine{State: stateStarted, Rules: &rules}
+	st.Expect(t, m.Fire("cancel"), nil)
+	st.Expect(t, m.State.ID(), stateCancelled.ID())
+}
+
+func TestRulesetGlobalGuard(t *testing.T) {
+	frozen := false
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+	)
+	rules.AddGlobalGuard(func(start *fsm.State, goal *fsm.State) error {
+		if frozen {
+			return testError
+		}
+		return nil
+	})
+
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	// global guards don't open transitions without rule
+	st.Expect(t, rules.Permitted(&statePending, &stateFinished).Error(), "No rules found for pending to finished")
+
+	frozen = true
+	err := rules.Permitted(&statePending, &stateStarted)
+	st.Expect(t, errors.Is(err, testError), true)
+	var terr *fsm.TransitionError
+	st.Assert(t, errors.As(err, &terr), true)
+	st.Expect(t, terr.Guard, 1)
+	st.Expect(t, errors.Is(rules.Permitted(&stateStarted, &stateFinished), testError), true)
+
+	clone := rules.Clone()
+	st.Expect(t, errors.Is(clone.Permitted(&statePending, &stateStarted), testError), true)
+}
+
+func TestLinearFlow(t *testing.T) {
+	rules := fsm.LinearFlow(statePending, stateStarted, stateFinished)
+
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	st.Expect(t, rules.Permitted(&stateStarted, &stateFinished), nil)
+	st.Reject(t, rules.Permitted(&statePending, &stateFinished), nil)
+	st.Reject(t, rules.Permitted(&stateStarted, &statePending), nil)
+
+	rules.AddTransitions(
+		fsm.NewTransition(statePending, stateFinished),
+		fsm.NewTransition(stateStarted, statePending),
+	)
+	st.Expect(t, rules.Permitted(&statePending, &stateFinished), nil)
+	st.Expect(t, rules.Permitted(&stateStarted, &statePending), nil)
+}
+
+func TestString(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(stateStarted, stateFinished),
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(fsm.AnyState, statePending),
+	)
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return nil
+	})
+
+	st.Expect(t, fmt.Sprint(fsm.NewTransition(statePending, stateStarted)), "pending -> started")
+	st.Expect(t, fmt.Sprint(rules), "[* -> pending (1 guard), pending -> started (1 guard), started -> finished (2 guards)]")
+	st.Expect(t, fmt.Sprintf("%v", &rules), fmt.Sprint(rules))
+	st.Expect(t, fsm.Ruleset{}.String(), "[]")
+	st.Expect(t, fmt.Sprintf("%v", statePending), "pending")
+	st.Expect(t, fsm.State{}.String(), "<nil>")
+}
+
+func TestRulesetAddTransitionStrict(t *testing.T) {
+	rules := fsm.Ruleset{}
+	st.Expect(t, rules.AddTransitionStrict(fsm.NewTransition(statePending, stateStarted)), nil)
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+
+	err := rules.AddTransitionStrict(fsm.NewTransition(statePending, stateStarted))
+	st.Expect(t, errors.Is(err, fsm.ErrDuplicateTransition), true)
+	st.Expect(t, err.Error(), "duplicate transition pending -> started")
+	st.Expect(t, rules.String(), "[pending -> started (1 guard)]")
+
+	// transitions with custom rules count as existing
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return nil
+	})
+	st.Expect(t, errors.Is(rules.AddTransitionStrict(fsm.NewTransition(stateStarted, stateFinished)), fsm.ErrDuplicateTransition), true)
 }
 
 func TestMachineTransition(t *testing.T) {
@@ -87,12 +479,13 @@ func TestMachineTransition(t *testing.T) {
 
 	// should not be able to transition to the current state
 	err = the_machine.Transition(statePending)
-	st.Expect(t, err, errors.New("No rules found for pending to pending"))
+	st.Expect(t, err.Error(), "No rules found for pending to pending")
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
 	st.Expect(t, the_machine.State, statePending)
 
 	// should not be able to skip states
 	err = the_machine.Transition(stateFinished)
-	st.Expect(t, err, errors.New("No rules found for pending to finished"))
+	st.Expect(t, err.Error(), "No rules found for pending to finished")
 	st.Expect(t, the_machine.State, statePending)
 
 	// should be able to transition to the next valid state
@@ -101,25 +494,1166 @@ func TestMachineTransition(t *testing.T) {
 	st.Expect(t, the_machine.State, stateStarted)
 }
 
+func TestMachineTransitionGuardError(t *testing.T) {
+	errTimeout := errors.New("connection timed out")
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		return errTimeout
+	})
+
+	m := fsm.Machine{State: statePending, Rules: &rules}
+	err := m.Transition(stateStarted)
+	st.Expect(t, errors.Is(err, errTimeout), true)
+	st.Expect(t, errors.Is(err, testError), false)
+	st.Expect(t, m.State, statePending)
+}
+
+func TestMachineCallbacks(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+	)
+	m := fsm.Machine{State: statePending, Rules: &rules}
+
+	var calls []string
+	record := func(name string) fsm.Callback {
+		return func(start fsm.State, goal fsm.State) {
+			calls = append(calls, fmt.Sprintf("%s %v->%v", name, start.ID(), goal.ID()))
+		}
+	}
+	m.OnExit(statePending, record("exit"))
+	m.OnEnter(stateStarted, record("enter"), record("enter2"))
+	m.OnExit(stateStarted, record("exit"))
+	m.OnEnter(stateFinished, func(start fsm.State, goal fsm.State) {
+		st.Expect(t, m.State, stateFinished)
+	})
+
+	// denied transitions don't run callbacks
+	st.Reject(t, m.Transition(stateFinished), nil)
+	st.Expect(t, len(calls), 0)
+
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, m.Transition(stateFinished), nil)
+	st.Expect(t, calls, []string{
+		"exit pending->started",
+		"enter pending->started",
+		"enter2 pending->started",
+		"exit started->finished",
+	})
+}
+
+func TestMachineSelfTransition(t *testing.T) {
+	refreshed := fsm.NewState(fsm.String("started"))
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(stateStarted, stateStarted),
+		fsm.NewTransition(statePending, statePending),
+	)
+	rules.AddRule(fsm.NewTransition(statePending, statePending), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+
+	for _, quiet := range []bool{false, true} {
+		var opts []func(*fsm.Machine)
+		if quiet {
+			opts = append(opts, fsm.WithQuietSelfTransitions())
+		}
+		m := fsm.New(opts...)
+		m.Rules = &rules
+		m.State = stateStarted
+
+		calls := 0
+		count := func(start fsm.State, goal fsm.State) { calls++ }
+		m.OnExit(stateStarted, count)
+		m.OnEnter(stateStarted, count)
+		m.OnExit(statePending, count)
+
+		st.Expect(t, m.Transition(refreshed), nil, 0)
+		st.Expect(t, m.State, refreshed)
+		if quiet {
+			st.Expect(t, calls, 0)
+		} else {
+			st.Expect(t, calls, 2)
+		}
+
+		// denied self transitions run no callbacks
+		m.State = statePending
+		before := calls
+		st.Expect(t, errors.Is(m.Transition(statePending), testError), true)
+		st.Expect(t, calls, before)
+	}
+}
+
+func TestMachineAvailableTransitions(t *testing.T) {
+	stateCancelled := fsm.NewState(fsm.String("cancelled"))
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(statePending, stateCancelled),
+		fsm.NewTransition(statePending, stateFinished),
+		fsm.NewTransition(stateStarted, stateFinished),
+	)
+	rules.AddRule(fsm.NewTransition(statePending, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+
+	m := fsm.Machine{State: statePending, Rules: &rules}
+	st.Expect(t, m.AvailableTransitions(), []fsm.ID{fsm.String("cancelled"), fsm.String("started")})
+
+	m.State = stateFinished
+	st.Expect(t, m.AvailableTransitions(), []fsm.ID{})
+
+	// the machine's evaluation applies
+	rules = fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted))
+	m = fsm.Machine{State: statePending, Rules: &rules}
+	st.Expect(t, m.AvailableTransitions(), []fsm.ID{})
+	n := fsm.New(fsm.WithInitialState(statePending), fsm.WithRules(&rules), fsm.WithEmptyGuardPolicy(fsm.PolicyOpen))
+	st.Expect(t, n.CanTransition(stateStarted), true)
+	st.Expect(t, n.AvailableTransitions(), []fsm.ID{stateStarted.ID()})
+	n = fsm.New(fsm.WithInitialState(statePending), fsm.WithRules(&rules), fsm.WithEmptyGuardPolicy(fsm.PolicyOpen),
+		fsm.WithPreTransition(func(start fsm.State, goal fsm.State) error { return testError }))
+	st.Expect(t, n.CanTransition(stateStarted), false)
+	st.Expect(t, n.AvailableTransitions(), []fsm.ID{})
+}
+
+func TestMachineConcurrentTransition(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, statePending),
+	)
+	m := fsm.New()
+	m.State = statePending
+	m.Rules = &rules
+
+	var wg sync.WaitGroup
+	var moves int64
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			goal := stateStarted
+			if i%2 == 0 {
+				goal = statePending
+			}
+			for j := 0; j < 100; j++ {
+				if m.Transition(goal) == nil {
+					atomic.AddInt64(&moves, 1)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// every successful transition toggled the state
+	expected := statePending
+	if moves%2 == 1 {
+		expected = stateStarted
+	}
+	st.Expect(t, m.Current(), expected)
+}
+
+func TestMachineTransitionCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var ran []string
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	rules.AddRuleCtx(fsm.NewTransition(statePending, stateStarted),
+		func(ctx context.Context, start *fsm.State, goal *fsm.State) error {
+			ran = append(ran, "ctx")
+			st.Expect(t, ctx.Value(ctxKey{}), "value")
+			return nil
+		},
+		fsm.Guard(func(start *fsm.State, goal *fsm.State) error {
+			ran = append(ran, "plain")
+			cancel()
+			return nil
+		}).Ctx(),
+		func(ctx context.Context, start *fsm.State, goal *fsm.State) error {
+			ran = append(ran, "cancelled")
+			return nil
+		},
+	)
+
+	m := fsm.Machine{State: statePending, Rules: &rules}
+	err := m.TransitionCtx(context.WithValue(ctx, ctxKey{}, "value"), stateStarted)
+	st.Expect(t, err, context.Canceled)
+	st.Expect(t, ran, []string{"ctx", "plain"})
+	st.Expect(t, m.State, statePending)
+
+	// a guard failing because of the context reports the context error
+	rules = fsm.Ruleset{}
+	rules.AddRuleCtx(fsm.NewTransition(statePending, stateStarted), func(ctx context.Context, start *fsm.State, goal *fsm.State) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	st.Expect(t, rules.PermittedCtx(ctx, &statePending, &stateStarted), context.DeadlineExceeded)
+}
+
+func TestMachineGuardTimeout(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+	)
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+
+	baseline := runtime.NumGoroutine()
+	m := fsm.New(fsm.WithGuardTimeout(10 * time.Millisecond))
+	m.State = statePending
+	m.Rules = &rules
+
+	begin := time.Now()
+	st.Expect(t, m.Transition(stateStarted), fsm.ErrGuardTimeout)
+	st.Expect(t, time.Since(begin) < 100*time.Millisecond, true)
+	st.Expect(t, m.Current(), statePending)
+
+	// the slow guard finishes in the background
+	time.Sleep(150 * time.Millisecond)
+	st.Expect(t, runtime.NumGoroutine() <= baseline, true)
+
+	// fast guards are unaffected
+	m.State = stateStarted
+	st.Expect(t, m.Transition(stateFinished), nil)
+	st.Expect(t, m.Transition(statePending).Error(), "No rules found for finished to pending")
+
+	// a cancelled parent context is not reported as a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m.State = statePending
+	st.Expect(t, m.TransitionCtx(ctx, stateStarted), context.Canceled)
+}
+
+func TestMachineCanTransition(t *testing.T) {
+	rules := fsm.LinearFlow(statePending, stateStarted, stateFinished)
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithHistory())
+	st.Expect(t, m.CanTransition(stateStarted), false)
+
+	m.Rules = &rules
+	entered := 0
+	m.OnEnter(stateStarted, func(start fsm.State, goal fsm.State) { entered++ })
+	for i := 0; i < 3; i++ {
+		st.Expect(t, m.CanTransition(stateStarted), true)
+		st.Expect(t, m.CanTransition(stateFinished), false)
+	}
+	st.Expect(t, m.Current(), statePending)
+	st.Expect(t, entered, 0)
+	st.Expect(t, len(m.History()), 0)
+}
+
+func TestMachineTransitionPath(t *testing.T) {
+	rules := fsm.LinearFlow(statePending, stateStarted, stateFinished)
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithHistory())
+	m.Rules = &rules
+
+	st.Expect(t, m.TransitionPath(stateStarted, stateFinished), nil)
+	st.Expect(t, m.Current(), stateFinished)
+	st.Expect(t, len(m.History()), 2)
+
+	rules.AddTransition(fsm.NewTransition(stateFinished, statePending))
+	err := m.TransitionPath(statePending, stateStarted, statePending)
+	var perr *fsm.PathError
+	st.Assert(t, errors.As(err, &perr), true)
+	st.Expect(t, perr.Step, 2)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+	st.Expect(t, err.Error(), "Path step 2 failed: No rules found for started to pending")
+	st.Expect(t, m.Current(), stateFinished)
+	st.Expect(t, len(m.History()), 2)
+
+	// a failing path isn't performed at all
+	var events []string
+	m = fsm.New(fsm.WithInitialState(statePending), fsm.WithHistoryLimit(2), fsm.WithStats())
+	m.Rules = &rules
+	st.Expect(t, m.TransitionPath(stateStarted, stateFinished), nil)
+	m.OnEnter(stateStarted, func(start fsm.State, goal fsm.State) {
+		events = append(events, "enter started")
+	})
+	sub := m.Subscribe()
+	st.Expect(t, m.TransitionPath(statePending, stateStarted, statePending) != nil, true)
+	st.Expect(t, m.Current(), stateFinished)
+	st.Expect(t, len(m.History()), 2)
+	st.Expect(t, m.History()[0].To, stateStarted)
+	st.Expect(t, m.History()[1].To, stateFinished)
+	st.Expect(t, events, []string(nil))
+	st.Expect(t, m.Stats()[fsm.NewTransition(stateFinished, statePending)].Attempts, 0)
+	select {
+	case e := <-sub:
+		t.Fatalf("unexpected event %v", e)
+	default:
+	}
+
+	// guards changing their mind are reverted
+	flaky := fsm.LinearFlow(statePending, stateStarted, stateFinished)
+	calls := 0
+	flaky.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		calls++
+		if calls > 1 {
+			return testError
+		}
+		return nil
+	})
+	m = fsm.New(fsm.WithInitialState(statePending), fsm.WithHistory())
+	m.Rules = &flaky
+	err = m.TransitionPath(stateStarted, stateFinished)
+	st.Assert(t, errors.As(err, &perr), true)
+	st.Expect(t, perr.Step, 1)
+	st.Expect(t, calls, 2)
+	st.Expect(t, m.Current(), statePending)
+	st.Expect(t, len(m.History()), 0)
+}
+
+func TestMachineTryTransition(t *testing.T) {
+	errDatabase := errors.New("database is down")
+	approved, broken := false, false
+	rules := fsm.LinearFlow(statePending, stateStarted, stateFinished)
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		if broken {
+			return errDatabase
+		}
+		if !approved {
+			return fsm.Deny("not approved")
+		}
+		return nil
+	})
+
+	m := fsm.New()
+	ok, err := m.TryTransition(stateStarted)
+	st.Expect(t, ok, false)
+	st.Expect(t, err, fsm.ErrNoRules)
+
+	m.Rules = &rules
+	m.State = statePending
+
+	// transitions without rule are denials
+	ok, err = m.TryTransition(stateFinished)
+	st.Expect(t, ok, false)
+	st.Expect(t, err, nil)
+	m.State = stateStarted
+	ok, err = m.TryTransition(stateStarted)
+	st.Expect(t, ok, false)
+	st.Expect(t, err, nil)
+
+	ok, err = m.TryTransition(stateFinished)
+	st.Expect(t, ok, false)
+	st.Expect(t, err, nil)
+
+	broken = true
+	ok, err = m.TryTransition(stateFinished)
+	st.Expect(t, ok, false)
+	st.Expect(t, errors.Is(err, errDatabase), true)
+
+	broken, approved = false, true
+	ok, err = m.TryTransition(stateFinished)
+	st.Expect(t, ok, true)
+	st.Expect(t, err, nil)
+	st.Expect(t, m.Current(), stateFinished)
+
+	// leaving a terminal state is a denial
+	rules.MarkTerminal(stateFinished)
+	ok, err = m.TryTransition(statePending)
+	st.Expect(t, ok, false)
+	st.Expect(t, err, nil)
+}
+
+func TestMachineMustTransition(t *testing.T) {
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	m := fsm.Machine{State: statePending, Rules: &rules}
+
+	m.MustTransition(stateStarted)
+	st.Expect(t, m.State, stateStarted)
+
+	defer func() {
+		err, ok := recover().(error)
+		st.Assert(t, ok, true)
+		st.Expect(t, err.Error(), "Cannot transition from started to finished: No rules found for started to finished")
+		st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+	}()
+	m.MustTransition(stateFinished)
+	t.Error("MustTransition should have panicked")
+}
+
+func TestMachineValidate(t *testing.T) {
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+
+	m := fsm.New()
+	st.Expect(t, m.Validate(), fsm.ErrNoRules)
+	st.Expect(t, m.Transition(stateStarted), fsm.ErrNoRules)
+	st.Expect(t, m.Fire("start"), fsm.ErrNoRules)
+	st.Expect(t, m.AvailableTransitions(), []fsm.ID{})
+
+	m.Rules = &rules
+	st.Expect(t, m.Validate(), fsm.ErrNoState)
+	st.Expect(t, m.Transition(stateStarted), fsm.ErrNoState)
+
+	m.State = statePending
+	st.Expect(t, m.Validate(), nil)
+	st.Expect(t, m.Transition(fsm.State{}), fsm.ErrNoState)
+	st.Expect(t, m.Transition(stateStarted), nil)
+}
+
+func TestSharedRuleset(t *testing.T) {
+	rules := fsm.LinearFlow(statePending, stateStarted, stateFinished)
+
+	// without machine
+	a, b := statePending, stateStarted
+	st.Expect(t, rules.Transition(&a, stateStarted), nil)
+	st.Expect(t, rules.Transition(&b, stateFinished), nil)
+	st.Reject(t, rules.Transition(&a, statePending), nil)
+	st.Expect(t, a, stateStarted)
+	st.Expect(t, b, stateFinished)
+
+	// with machines sharing the rules and configuration
+	entered := 0
+	base := fsm.New(fsm.WithHistory())
+	base.Rules = &rules
+	base.OnEnter(stateStarted, func(start fsm.State, goal fsm.State) { entered++ })
+
+	m1, m2 := base.For(statePending), base.For(stateStarted)
+	st.Expect(t, m1.Rules == m2.Rules, true)
+	st.Expect(t, m1.Transition(stateStarted), nil)
+	st.Expect(t, m2.Transition(stateFinished), nil)
+	st.Expect(t, m1.Current(), stateStarted)
+	st.Expect(t, m2.Current(), stateFinished)
+	st.Expect(t, entered, 1)
+	st.Expect(t, len(m1.History()), 1)
+	st.Expect(t, len(base.History()), 0)
+
+	// rules added later are seen by every machine
+	rules.AddTransition(fsm.NewTransition(stateFinished, statePending))
+	st.Expect(t, m2.Transition(statePending), nil)
+}
+
+func TestMachineWithInitialState(t *testing.T) {
+	rules := fsm.LinearFlow(statePending, stateStarted)
+
+	m := fsm.New(fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	st.Expect(t, m.Validate(), nil)
+	st.Expect(t, m.Current(), statePending)
+	st.Expect(t, m.Transition(stateStarted), nil)
+
+	m = fsm.New(fsm.WithInitialState(fsm.State{}))
+	m.Rules = &rules
+	m.State = statePending
+	st.Expect(t, m.Validate(), fsm.ErrNoState)
+	st.Expect(t, m.Transition(stateStarted), fsm.ErrNoState)
+}
+
+func TestMachineStrictStates(t *testing.T) {
+	rules := fsm.LinearFlow(statePending, stateStarted, stateFinished)
+	typo := fsm.NewState(fsm.String("finshed"))
+
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithStrictStates())
+	m.Rules = &rules
+
+	err := m.Transition(typo)
+	st.Expect(t, errors.Is(err, fsm.ErrUnknownState), true)
+	st.Expect(t, err.Error(), "unknown state finshed")
+
+	// known but not permitted from here
+	err = m.Transition(stateFinished)
+	st.Expect(t, errors.Is(err, fsm.ErrUnknownState), false)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+
+	// without the option both are plain invalid transitions
+	m = fsm.New(fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	err = m.Transition(typo)
+	st.Expect(t, errors.Is(err, fsm.ErrUnknownState), false)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+}
+
+// concurrencyRules returns a ruleset with n sleeping guards from pending to
+// started, recording in max the most guards seen running at once
+func concurrencyRules(n int, max *int32) fsm.Ruleset {
+	var running int32
+	rules := fsm.Ruleset{}
+	for i := 0; i < n; i++ {
+		rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+			now := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				seen := atomic.LoadInt32(max)
+				if now <= seen || atomic.CompareAndSwapInt32(max, seen, now) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			return nil
+		})
+	}
+	return rules
+}
+
+func TestMachineGuardConcurrency(t *testing.T) {
+	var max int32
+	rules := concurrencyRules(50, &max)
+	m := fsm.New(fsm.WithGuardConcurrency(5), fsm.WithInitialState(statePending))
+	m.Rules = &rules
+
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, m.Current(), stateStarted)
+	st.Assert(t, max <= 5, true)
+	st.Assert(t, max > 1, true)
+
+	// Unlimited
+	max = 0
+	m = fsm.New(fsm.WithGuardConcurrency(0), fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Assert(t, max > 5, true)
+
+	// All guards must still pass, the failing one stops the others
+	var calls int32
+	rules = fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		atomic.AddInt32(&calls, 1)
+		return testError
+	})
+	for i := 0; i < 10; i++ {
+		rules.AddRuleCtx(fsm.NewTransition(statePending, stateStarted), func(ctx context.Context, start *fsm.State, goal *fsm.State) error {
+			atomic.AddInt32(&calls, 1)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}
+	m = fsm.New(fsm.WithGuardConcurrency(1), fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	err := m.Transition(stateStarted)
+	st.Expect(t, errors.Is(err, testError), true)
+	st.Expect(t, atomic.LoadInt32(&calls), int32(1))
+	st.Expect(t, m.Current(), statePending)
+
+	m = fsm.New(fsm.WithGuardConcurrency(3), fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	err = m.Transition(stateStarted)
+	st.Expect(t, errors.Is(err, testError), true)
+	st.Expect(t, m.Current(), statePending)
+
+	// No rule
+	st.Expect(t, errors.Is(m.Transition(stateFinished), fsm.ErrInvalidTransition), true)
+}
+
+// cart is state data implementing fsm.Cloner
+type cart struct {
+	status string
+	items  []string
+}
+
+func (c *cart) ID() fsm.ID { return c.status }
+
+func (c *cart) Clone() fsm.IDer {
+	return &cart{c.status, append([]string(nil), c.items...)}
+}
+
+func TestMachineClone(t *testing.T) {
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	m := fsm.New(fsm.WithHistory(), fsm.WithInitialState(statePending))
+	m.Rules = &rules
+
+	c := m.Clone()
+	c.Rules.AddTransition(fsm.NewTransition(stateStarted, stateFinished))
+	st.Expect(t, c.Transition(stateStarted), nil)
+	st.Expect(t, c.Transition(stateFinished), nil)
+	st.Expect(t, len(c.History()), 2)
+
+	st.Expect(t, m.Current(), statePending)
+	st.Expect(t, len(m.History()), 0)
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, m.CanTransition(stateFinished), false)
+
+	// the history is copied
+	c = m.Clone()
+	st.Expect(t, c.Current(), stateStarted)
+	st.Expect(t, c.History(), m.History())
+	st.Expect(t, c.Rollback(), nil)
+	st.Expect(t, len(m.History()), 1)
+
+	// state data implementing Cloner is copied
+	data := &cart{status: "pending", items: []string{"book"}}
+	m.State = fsm.NewState(data)
+	c = m.Clone()
+	c.State.I.(*cart).items[0] = "pen"
+	st.Expect(t, data.items, []string{"book"})
+	st.Expect(t, c.State.ID(), "pending")
+}
+
+func TestRulesetPermittedDetailed(t *testing.T) {
+	rules := fsm.Ruleset{}
+	rules.AddTransition(fsm.NewTransition(statePending, stateStarted))
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		return nil
+	})
+	rules.AddGlobalGuard(func(start *fsm.State, goal *fsm.State) error {
+		return fsm.Deny("closed")
+	})
+
+	errs := rules.PermittedDetailed(&statePending, &stateStarted)
+	st.Expect(t, len(errs), 2)
+	st.Expect(t, errs[0].Guard, 1)
+	st.Expect(t, errs[0].Err, testError)
+	st.Expect(t, errs[1].Guard, 3)
+	st.Expect(t, errors.Is(errs[1], fsm.ErrDenied), true)
+
+	// no rule
+	errs = rules.PermittedDetailed(&statePending, &stateFinished)
+	st.Expect(t, len(errs), 1)
+	st.Expect(t, errs[0].Guard, -1)
+
+	rules = fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	st.Expect(t, len(rules.PermittedDetailed(&statePending, &stateStarted)), 0)
+}
+
+func TestRulesetPermittedAllErrors(t *testing.T) {
+	errDatabase := errors.New("database unavailable")
+	errPayments := errors.New("payments unavailable")
+	rules := fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		return errDatabase
+	}, func(start *fsm.State, goal *fsm.State) error {
+		return errPayments
+	})
+
+	err := rules.PermittedAllErrors(&statePending, &stateStarted)
+	st.Expect(t, errors.Is(err, errDatabase), true)
+	st.Expect(t, errors.Is(err, errPayments), true)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+	var terr *fsm.TransitionError
+	st.Expect(t, errors.As(err, &terr), true)
+	st.Expect(t, terr.Guard, 0)
+
+	st.Expect(t, errors.Is(rules.PermittedAllErrors(&statePending, &stateFinished), fsm.ErrInvalidTransition), true)
+
+	rules = fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	st.Expect(t, rules.PermittedAllErrors(&statePending, &stateStarted), nil)
+}
+
+func TestRulesetNamedRule(t *testing.T) {
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	rules.AddNamedRule(fsm.NewTransition(statePending, stateStarted), "paid", func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+
+	err := rules.Permitted(&statePending, &stateStarted)
+	var terr *fsm.TransitionError
+	st.Expect(t, errors.As(err, &terr), true)
+	st.Expect(t, terr.Name, "paid")
+	st.Expect(t, terr.Guard, 1)
+	st.Expect(t, err.Error(), "Guard paid failed from pending to started: "+testError.Error())
+
+	errs := rules.PermittedDetailed(&statePending, &stateStarted)
+	st.Expect(t, len(errs), 1)
+	st.Expect(t, errs[0].Name, "paid")
+
+	// unnamed guards are only identified by their index
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+	err = rules.Permitted(&stateStarted, &stateFinished)
+	st.Expect(t, errors.As(err, &terr), true)
+	st.Expect(t, terr.Guard, 0)
+	st.Expect(t, terr.Name, "")
+}
+
+func TestMachineIdempotentNoOp(t *testing.T) {
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	guarded := 0
+	rules.AddGlobalGuard(func(start *fsm.State, goal *fsm.State) error {
+		guarded++
+		return nil
+	})
+
+	for _, idempotent := range []bool{false, true} {
+		opts := []func(*fsm.Machine){fsm.WithHistory(), fsm.WithInitialState(statePending)}
+		if idempotent {
+			opts = append(opts, fsm.WithIdempotentNoOp())
+		}
+		m := fsm.New(opts...)
+		m.Rules = &rules
+
+		calls := 0
+		m.OnEnter(stateStarted, func(start fsm.State, goal fsm.State) { calls++ })
+		m.OnExit(stateStarted, func(start fsm.State, goal fsm.State) { calls++ })
+		st.Expect(t, m.Transition(stateStarted), nil)
+		guarded, calls = 0, 0
+
+		err := m.Transition(stateStarted)
+		if idempotent {
+			st.Expect(t, err, nil)
+		} else {
+			st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+		}
+		st.Expect(t, m.Current(), stateStarted)
+		st.Expect(t, guarded, 0)
+		st.Expect(t, calls, 0)
+		st.Expect(t, len(m.History()), 1)
+
+		// other transitions still need a rule
+		st.Expect(t, errors.Is(m.Transition(stateFinished), fsm.ErrInvalidTransition), true)
+	}
+}
+
+func TestGuardPanic(t *testing.T) {
+	rules := fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		panic("boom")
+	})
+
+	err := rules.Permitted(&statePending, &stateStarted)
+	st.Expect(t, errors.Is(err, fsm.ErrGuardPanicked), true)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+	st.Expect(t, err.Error(), "Guard failed from pending to started: guard panicked: boom")
+
+	errs := rules.PermittedDetailed(&statePending, &stateStarted)
+	st.Expect(t, len(errs), 1)
+	st.Expect(t, errors.Is(errs[0], fsm.ErrGuardPanicked), true)
+
+	// also when the guards run in their own goroutines
+	for _, opt := range []func(*fsm.Machine){fsm.WithGuardConcurrency(2), fsm.WithGuardTimeout(time.Second)} {
+		m := fsm.New(opt, fsm.WithInitialState(statePending))
+		m.Rules = &rules
+		st.Expect(t, errors.Is(m.Transition(stateStarted), fsm.ErrGuardPanicked), true)
+		st.Expect(t, m.Current(), statePending)
+	}
+}
+
+func TestRulesetAddRuleTiered(t *testing.T) {
+	var order []string
+	guard := func(name string, err error) fsm.Guard {
+		return func(start *fsm.State, goal *fsm.State) error {
+			order = append(order, name)
+			return err
+		}
+	}
+
+	rules := fsm.Ruleset{}
+	rules.AddRuleTiered(fsm.NewTransition(statePending, stateStarted), 1, guard("billing", nil))
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), guard("logged in", nil))
+	rules.AddGlobalGuard(guard("global", nil))
+
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	st.Expect(t, order, []string{"logged in", "global", "billing"})
+
+	// a failing lower tier keeps the higher ones from running, the index
+	// is still the one of the guard within the rule
+	order = nil
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), guard("failing", testError))
+	err := rules.Permitted(&statePending, &stateStarted)
+	var terr *fsm.TransitionError
+	st.Expect(t, errors.As(err, &terr), true)
+	st.Expect(t, terr.Guard, 2)
+	st.Expect(t, order, []string{"logged in", "failing"})
+
+	// also when running the guards concurrently
+	var calls int32
+	rules = fsm.Ruleset{}
+	for i := 0; i < 5; i++ {
+		rules.AddRuleTiered(fsm.NewTransition(statePending, stateStarted), 1, func(start *fsm.State, goal *fsm.State) error {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		})
+		rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		})
+	}
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		time.Sleep(5 * time.Millisecond)
+		return testError
+	})
+	m := fsm.New(fsm.WithGuardConcurrency(0), fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	st.Expect(t, errors.Is(m.Transition(stateStarted), testError), true)
+	st.Expect(t, atomic.LoadInt32(&calls), int32(0))
+
+	rules.RemoveGuard(fsm.NewTransition(statePending, stateStarted), 10)
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, atomic.LoadInt32(&calls), int32(5))
+}
+
+func TestMachineTransitionR(t *testing.T) {
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	m := fsm.New(fsm.WithInitialState(statePending))
+	m.Rules = &rules
+
+	from, err := m.TransitionR(stateStarted)
+	st.Expect(t, err, nil)
+	st.Expect(t, from, statePending)
+	st.Expect(t, m.Current(), stateStarted)
+
+	from, err = m.TransitionR(stateFinished)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+	st.Expect(t, from, stateStarted)
+	st.Expect(t, m.Current(), stateStarted)
+}
+
+func TestMachineTransitionState(t *testing.T) {
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithRules(&rules))
+	st.Expect(t, m.States(), []fsm.ID{statePending.ID(), stateStarted.ID()})
+
+	state, err := m.TransitionState(context.Background(), stateStarted)
+	st.Expect(t, err, nil)
+	st.Expect(t, state, stateStarted)
+
+	state, err = m.TransitionState(context.Background(), stateFinished)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+	st.Expect(t, state, stateStarted)
+
+	st.Expect(t, fsm.New().States(), []fsm.ID(nil))
+}
+
+func TestRulesetAddRuleWithoutGuards(t *testing.T) {
+	rules := fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted))
+	rules.AddRuleCtx(fsm.NewTransition(statePending, stateStarted))
+	rules.AddNamedRule(fsm.NewTransition(statePending, stateStarted), "none")
+	rules.AddRuleTiered(fsm.NewTransition(statePending, stateStarted), 1)
+
+	st.Expect(t, rules.HasRule(fsm.NewTransition(statePending, stateStarted)), true)
+	st.Expect(t, rules.GuardCount(fsm.NewTransition(statePending, stateStarted)), 0)
+	st.Expect(t, errors.Is(rules.Permitted(&statePending, &stateStarted), fsm.ErrInvalidTransition), true)
+}
+
+func TestMachineEmptyGuardPolicy(t *testing.T) {
+	rules := fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted))
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished))
+
+	m := fsm.New(fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	st.Expect(t, m.Transition(stateStarted).Error(), "No rules found for pending to started")
+
+	m = fsm.New(fsm.WithInitialState(statePending), fsm.WithEmptyGuardPolicy(fsm.PolicyDeny))
+	m.Rules = &rules
+	st.Expect(t, errors.Is(m.Transition(stateStarted), fsm.ErrInvalidTransition), true)
+
+	m = fsm.New(fsm.WithInitialState(statePending), fsm.WithEmptyGuardPolicy(fsm.PolicyOpen))
+	m.Rules = &rules
+	st.Expect(t, m.Transition(stateStarted), nil)
+
+	// the default guard checks the origin, which any child of it passes
+	child := fsm.NewState(fsm.String("child"))
+	rules.SetParent(child, stateStarted)
+	m = fsm.New(fsm.WithInitialState(child), fsm.WithEmptyGuardPolicy(fsm.PolicyOriginCheck))
+	m.Rules = &rules
+	st.Expect(t, m.Transition(stateFinished), nil)
+	st.Expect(t, m.Current(), stateFinished)
+
+	// guards of the rule are run as usual whatever the policy
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+	m = fsm.New(fsm.WithInitialState(statePending), fsm.WithEmptyGuardPolicy(fsm.PolicyOpen))
+	m.Rules = &rules
+	st.Expect(t, errors.Is(m.Transition(stateStarted), testError), true)
+}
+
+// shipment is state data refusing to be shipped without address
+type shipment struct {
+	status  string
+	address string
+}
+
+func (s shipment) ID() fsm.ID { return fsm.String(s.status) }
+
+func (s shipment) CanEnter(goal fsm.State) error {
+	if goal.ID() == fsm.String("shipped") && s.address == "" {
+		return errors.New("no address")
+	}
+	return nil
+}
+
+func TestMachineEnterValidator(t *testing.T) {
+	shipped := fsm.NewState(fsm.String("shipped"))
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(fsm.String("packed"), shipped),
+		fsm.NewTransition(fsm.String("packed"), fsm.String("cancelled")),
+	)
+
+	exited := 0
+	m := fsm.New(fsm.WithInitialState(fsm.NewState(shipment{status: "packed"})))
+	m.Rules = &rules
+	m.OnExit(fsm.String("packed"), func(start fsm.State, goal fsm.State) { exited++ })
+
+	st.Expect(t, m.Transition(shipped).Error(), "no address")
+	st.Expect(t, m.CanTransition(shipped), false)
+	st.Expect(t, m.Current().ID(), fsm.String("packed"))
+	st.Expect(t, exited, 0)
+	st.Expect(t, m.CanTransition(fsm.NewState(fsm.String("cancelled"))), true)
+
+	m.State = fsm.NewState(shipment{status: "packed", address: "1 rue de Rivoli"})
+	st.Expect(t, m.Transition(shipped), nil)
+	st.Expect(t, exited, 1)
+}
+
+func TestMachineGuardMemoization(t *testing.T) {
+	var calls, rule, global int
+	guard := countingGuard(nil, &calls)
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), guard, countingGuard(nil, &rule))
+	rules.AddOriginGuard(statePending, guard)
+	rules.AddGlobalGuard(guard, countingGuard(nil, &global))
+
+	// without memoization
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	st.Expect(t, calls, 3)
+	st.Expect(t, rule+global, 2)
+
+	for _, opts := range [][]func(*fsm.Machine){{}, {fsm.WithGuardConcurrency(0)}} {
+		calls, rule, global = 0, 0, 0
+		m := fsm.New(append(opts, fsm.WithGuardMemoization(), fsm.WithInitialState(statePending))...)
+		m.Rules = &rules
+		st.Expect(t, m.Transition(stateStarted), nil)
+		st.Expect(t, calls, 1)
+		st.Expect(t, rule+global, 2)
+	}
+
+	// failures are reused too
+	calls = 0
+	rules = fsm.Ruleset{}
+	guard = countingGuard(testError, &calls)
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), guard)
+	rules.AddGlobalGuard(guard)
+	m := fsm.New(fsm.WithGuardMemoization(), fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	st.Expect(t, errors.Is(m.Transition(stateStarted), testError), true)
+	st.Expect(t, calls, 1)
+}
+
+func TestMachinePreTransition(t *testing.T) {
+	maintenance := errors.New("maintenance")
+	var calls []string
+	guarded := false
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		guarded = true
+		return nil
+	})
+	down := true
+
+	m := fsm.New(
+		fsm.WithInitialState(statePending),
+		fsm.WithPreTransition(func(start fsm.State, goal fsm.State) error {
+			calls = append(calls, fmt.Sprintf("first %v->%v", start, goal))
+			if down {
+				return maintenance
+			}
+			return nil
+		}),
+		fsm.WithPreTransition(func(start fsm.State, goal fsm.State) error {
+			calls = append(calls, "second")
+			return nil
+		}),
+	)
+	m.Rules = &rules
+
+	st.Expect(t, m.Transition(stateStarted), maintenance)
+	st.Expect(t, guarded, false)
+	st.Expect(t, calls, []string{"first pending->started"})
+	st.Expect(t, m.Current(), statePending)
+
+	down = false
+	calls = nil
+	st.Expect(t, m.For(statePending).Transition(stateStarted), nil)
+	st.Expect(t, guarded, true)
+	st.Expect(t, calls, []string{"first pending->started", "second"})
+}
+
+func TestMachineDryRun(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+	)
+	var entered int
+	o := &recordingObserver{}
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithHistory(), fsm.WithObserver(o))
+	m.Rules = &rules
+	m.OnEnter(stateStarted, func(start fsm.State, goal fsm.State) { entered++ })
+
+	final, results := m.DryRun(stateStarted, statePending, stateFinished)
+	st.Expect(t, final, stateFinished)
+	st.Expect(t, len(results), 3)
+	st.Expect(t, results[0], nil)
+	st.Expect(t, results[1].Error(), "No rules found for started to pending")
+	st.Expect(t, results[2], nil)
+
+	st.Expect(t, m.Current(), statePending)
+	st.Expect(t, len(m.History()), 0)
+	st.Expect(t, entered, 0)
+	st.Expect(t, len(o.events), 0)
+
+	final, results = m.DryRun()
+	st.Expect(t, final, statePending)
+	st.Expect(t, results, []error{})
+}
+
+func TestRulesetFrom(t *testing.T) {
+	archived := fsm.NewState(fsm.String("archived"))
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, archived),
+	)
+	// only leave a state which can be entered again
+	var reentry fsm.GuardCtx = func(ctx context.Context, start *fsm.State, goal *fsm.State) error {
+		r, ok := fsm.RulesetFrom(ctx)
+		if !ok {
+			return testError
+		}
+		if !r.HasRule(fsm.NewTransition(goal, start)) {
+			return fsm.Deny("no way back")
+		}
+		return nil
+	}
+	rules.AddRuleCtx(fsm.NewTransition(statePending, stateStarted), reentry)
+	rules.AddRuleCtx(fsm.NewTransition(stateStarted, archived), reentry)
+
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted).Error(), "Guard failed from pending to started: no way back")
+	rules.AddTransition(fsm.NewTransition(stateStarted, statePending))
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+
+	m := fsm.New(fsm.WithInitialState(stateStarted), fsm.WithGuardConcurrency(2))
+	m.Rules = &rules
+	st.Expect(t, errors.Is(m.Transition(archived), fsm.ErrDenied), true)
+
+	_, ok := fsm.RulesetFrom(context.Background())
+	st.Expect(t, ok, false)
+}
+
+func TestRulesetAddRuleAny(t *testing.T) {
+	var calls int
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	rules.AddRuleAny(fsm.NewTransition(statePending, stateStarted),
+		countingGuard(testError, &calls),
+		countingGuard(testError, &calls),
+		countingGuard(nil, &calls),
+		countingGuard(nil, &calls),
+	)
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	st.Expect(t, calls, 3)
+	st.Expect(t, rules.GuardCount(fsm.NewTransition(statePending, stateStarted)), 2)
+
+	// the default guard still has to pass
+	st.Expect(t, errors.Is(rules.Permitted(&stateFinished, &stateStarted), fsm.ErrInvalidTransition), true)
+
+	denied := errors.New("no share link")
+	rules.AddRuleAny(fsm.NewTransition(stateStarted, stateFinished),
+		countingGuard(testError, &calls),
+		func(start *fsm.State, goal *fsm.State) error { return denied },
+	)
+	err := rules.Permitted(&stateStarted, &stateFinished)
+	st.Expect(t, errors.Is(err, denied), true)
+	st.Expect(t, err.Error(), "Guard failed from started to finished: no share link")
+
+	// Allow permits like it does with AddRule
+	adminAllow := func(start *fsm.State, goal *fsm.State) error { return fsm.Allow }
+	ownerDeny := func(start *fsm.State, goal *fsm.State) error { return fsm.Deny("not owner") }
+	rules.AddRuleAny(fsm.NewTransition(stateFinished, statePending), adminAllow, ownerDeny)
+	rules.AddRule(fsm.NewTransition(stateFinished, stateStarted), adminAllow, ownerDeny)
+	st.Expect(t, rules.Permitted(&stateFinished, &statePending), nil)
+	st.Expect(t, rules.Permitted(&stateFinished, &stateStarted), nil)
+}
+
+func TestMachineWithRules(t *testing.T) {
+	rules := fsm.Ruleset{}
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithRules(&rules))
+	st.Expect(t, m.Rules, &rules)
+
+	// rules added after New apply to the machine
+	rules.AddTransition(fsm.NewTransition(statePending, stateStarted))
+	rules.AddGlobalGuard(func(start *fsm.State, goal *fsm.State) error {
+		if goal.ID() == stateFinished.ID() {
+			return testError
+		}
+		return nil
+	})
+	rules.AddTransition(fsm.NewTransition(stateStarted, stateFinished))
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, errors.Is(m.Transition(stateFinished), testError), true)
+
+	// clones don't see later changes
+	clone := rules.Clone()
+	n := fsm.New(fsm.WithInitialState(stateStarted), fsm.WithRules(&clone))
+	rules.RemoveRule(fsm.NewTransition(stateStarted, stateFinished))
+	st.Expect(t, clone.HasRule(fsm.NewTransition(stateStarted, stateFinished)), true)
+	st.Expect(t, errors.Is(n.Transition(stateFinished), testError), true)
+}
+
+func TestMachineOnReject(t *testing.T) {
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithRules(&rules))
+
+	var calls []string
+	m.OnReject(func(start fsm.State, goal fsm.State, reason fsm.Reason) {
+		calls = append(calls, fmt.Sprintf("1 %s %s %s", start, goal, reason))
+	}, func(start fsm.State, goal fsm.State, reason fsm.Reason) {
+		calls = append(calls, fmt.Sprintf("2 %s %s %s", start, goal, reason))
+	})
+
+	st.Expect(t, m.Transition(stateFinished) != nil, true)
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, m.Transition(stateFinished) != nil, true)
+	st.Expect(t, calls, []string{
+		"1 pending finished no rule",
+		"2 pending finished no rule",
+		"1 started finished guard failed",
+		"2 started finished guard failed",
+	})
+
+	// not for operational errors
+	calls = nil
+	m.Rules = nil
+	st.Expect(t, m.Transition(stateFinished), fsm.ErrNoRules)
+	st.Expect(t, len(calls), 0)
+}
+
 func BenchmarkRulesetParallelGuarding(b *testing.B) {
 	rules := fsm.Ruleset{}
 	rules.AddTransition(fsm.NewTransition(statePending, stateStarted))
 	rules.AddTransition(fsm.NewTransition(stateStarted, stateFinished))
 
-	// Add two failing rules, one very slow and the other terribly fast
-	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start fsm.State, goal fsm.State) error {
-		time.Sleep(1 * time.Second)
+	// Add two failing rules, the terribly fast one first and the other very
+	// slow, which should never run
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
 		return testError
 	})
 
-	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start fsm.State, goal fsm.State) error {
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		time.Sleep(1 * time.Second)
 		return testError
 	})
 
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		rules.Permitted(stateStarted, stateFinished)
+		rules.Permitted(&stateStarted, &stateFinished)
+	}
+}
+
+func BenchmarkRulesetSequentialGuarding(b *testing.B) {
+	// Guards run in declared order on the calling goroutine, so a passing
+	// transition with two cheap guards should not need any goroutine or
+	// channel allocation.
+	rules := fsm.Ruleset{}
+	rules.AddTransition(fsm.NewTransition(stateStarted, stateFinished))
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return nil
+	})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		rules.Permitted(&stateStarted, &stateFinished)
 	}
 }
 
@@ -137,7 +1671,7 @@ func BenchmarkRulesetTransitionPermitted(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		rules.Permitted(some_thing, stateFinished)
+		rules.Permitted(&some_thing, &stateFinished)
 	}
 
 }
@@ -157,7 +1691,7 @@ func BenchmarkRulesetTransitionInvalid(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		rules.Permitted(some_thing, stateFinished)
+		rules.Permitted(&some_thing, &stateFinished)
 	}
 }
 
@@ -171,7 +1705,7 @@ func BenchmarkRulesetRuleForbids(b *testing.B) {
 	rules := fsm.Ruleset{}
 	rules.AddTransition(fsm.NewTransition(statePending, stateStarted))
 
-	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start fsm.State, goal fsm.State) error {
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
 		return testError
 	})
 
@@ -180,6 +1714,24 @@ func BenchmarkRulesetRuleForbids(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		rules.Permitted(some_thing, stateFinished)
+		rules.Permitted(&some_thing, &stateFinished)
+	}
+}
+
+func BenchmarkMachineGuardConcurrency(b *testing.B) {
+	var max int32
+	rules := concurrencyRules(50, &max)
+	m := fsm.New(fsm.WithGuardConcurrency(10))
+	m.Rules = &rules
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		m.State = statePending
+		m.Transition(stateStarted)
+	}
+	b.StopTimer()
+	if max > 10 {
+		b.Fatalf("%d guards ran at once", max)
 	}
 }
@@ -0,0 +1,154 @@
+// Package fsmhttp exposes a machine over HTTP, kept apart so the fsm
+// package doesn't depend on net/http:
+//
+//	http.Handle("/order/", http.StripPrefix("/order", fsmhttp.NewHandler(machine)))
+//
+// It serves GET /state, GET /transitions listing the states the machine
+// can transition to, and POST /transition with a body like {"to":"paid"}.
+// States are given by the string of their ID.
+package fsmhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/processout/fsm"
+)
+
+const (
+	errUnknownStateFormat = "%v %s"
+)
+
+// TransitionRequest is the body of POST /transition
+type TransitionRequest struct {
+	To string `json:"to"`
+}
+
+// StateResponse is the body answering GET /state and successful
+// transitions
+type StateResponse struct {
+	State string `json:"state"`
+}
+
+// TransitionsResponse is the body answering GET /transitions
+type TransitionsResponse struct {
+	Transitions []string `json:"transitions"`
+}
+
+// ErrorResponse is the body answering failed requests
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler serves a machine, see NewHandler
+type Handler struct {
+	machine *fsm.Machine
+	mux     *http.ServeMux
+}
+
+// NewHandler creates a handler serving m. POST /transition answers
+// 200 with the new state, 404 if the goal isn't a state of the rules,
+// 409 if there is no rule from the current state to it (or the current
+// state is terminal), 403 if a guard denied it (with an error matching
+// fsm.ErrDenied, see fsm.Deny), and 500 for other failures such as guard
+// errors, panics and timeouts, like fsm.Machine.TryTransition.
+func NewHandler(m *fsm.Machine) *Handler {
+	h := &Handler{machine: m, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/state", h.state)
+	h.mux.HandleFunc("/transitions", h.transitions)
+	h.mux.HandleFunc("/transition", h.transition)
+	return h
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) state(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	write(w, http.StatusOK, StateResponse{State: h.machine.Current().String()})
+}
+
+func (h *Handler) transitions(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	res := TransitionsResponse{Transitions: []string{}}
+	for _, id := range h.machine.AvailableTransitions() {
+		res.Transitions = append(res.Transitions, fmt.Sprint(id))
+	}
+	write(w, http.StatusOK, res)
+}
+
+func (h *Handler) transition(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	var req TransitionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.To == "" {
+		write(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request, expected {\"to\":\"<state>\"}"})
+		return
+	}
+	goal, known := h.goal(req.To)
+	state, err := h.machine.TransitionState(r.Context(), goal)
+	var terr *fsm.TransitionError
+	if !known && (errors.Is(err, fsm.ErrUnknownState) || errors.As(err, &terr) && terr.Guard == -1) {
+		write(w, http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf(errUnknownStateFormat, fsm.ErrUnknownState, req.To)})
+		return
+	}
+	if err != nil {
+		write(w, status(err), ErrorResponse{Error: err.Error()})
+		return
+	}
+	write(w, http.StatusOK, StateResponse{State: state.String()})
+}
+
+// goal returns the state of the rules whose ID is given as a string and
+// whether there is one, or else a String state for the predicate rules
+// (see fsm.Ruleset.AddRuleFunc), the goal being unknown if no rule applies
+func (h *Handler) goal(name string) (fsm.State, bool) {
+	for _, id := range h.machine.States() {
+		if fmt.Sprint(id) == name {
+			return fsm.IDState(id), true
+		}
+	}
+	return fsm.NewState(fsm.String(name)), false
+}
+
+// status returns the status code answering a failed transition
+func status(err error) int {
+	var terr *fsm.TransitionError
+	switch {
+	case errors.As(err, &terr) && terr.Guard == -1:
+		return http.StatusConflict
+	case errors.Is(err, fsm.ErrDenied):
+		return http.StatusForbidden
+	case errors.Is(err, fsm.ErrTerminalState):
+		return http.StatusConflict
+	case errors.Is(err, fsm.ErrUnknownState):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// allow answers 405 and returns false if r doesn't use the method
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	write(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"})
+	return false
+}
+
+// write answers with the status and v encoded as JSON
+func write(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,123 @@
+package fsmhttp_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+	"github.com/processout/fsm/fsmhttp"
+)
+
+func TestHandler(t *testing.T) {
+	pending := fsm.NewState(fsm.String("pending"))
+	paid := fsm.NewState(fsm.String("paid"))
+	shipped := fsm.NewState(fsm.String("shipped"))
+	refunded := fsm.NewState(fsm.String("refunded"))
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(pending, paid),
+		fsm.NewTransition(paid, shipped),
+		fsm.NewTransition(paid, refunded),
+		fsm.NewTransition(paid, pending),
+	)
+	rules.AddRule(fsm.NewTransition(paid, refunded), func(start *fsm.State, goal *fsm.State) error {
+		return fsm.Deny("refund window closed")
+	})
+	rules.AddRule(fsm.NewTransition(paid, shipped), func(start *fsm.State, goal *fsm.State) error {
+		return errors.New("carrier down")
+	})
+	rules.AddRule(fsm.NewTransition(paid, pending), func(start *fsm.State, goal *fsm.State) error {
+		panic("boom")
+	})
+	m := fsm.New(fsm.WithInitialState(pending))
+	m.Rules = &rules
+	h := fsmhttp.NewHandler(m)
+
+	do := func(method, path, body string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w.Code, strings.TrimSpace(w.Body.String())
+	}
+
+	code, body := do(http.MethodGet, "/state", "")
+	st.Expect(t, code, http.StatusOK)
+	st.Expect(t, body, `{"state":"pending"}`)
+
+	code, body = do(http.MethodGet, "/transitions", "")
+	st.Expect(t, code, http.StatusOK)
+	st.Expect(t, body, `{"transitions":["paid"]}`)
+
+	code, _ = do(http.MethodPost, "/transition", `{"to":"archived"}`)
+	st.Expect(t, code, http.StatusNotFound)
+
+	code, body = do(http.MethodPost, "/transition", `{"to":"shipped"}`)
+	st.Expect(t, code, http.StatusConflict)
+	st.Expect(t, body, `{"error":"No rules found for pending to shipped"}`)
+
+	code, body = do(http.MethodPost, "/transition", `{"to":"paid"}`)
+	st.Expect(t, code, http.StatusOK)
+	st.Expect(t, body, `{"state":"paid"}`)
+
+	code, body = do(http.MethodPost, "/transition", `{"to":"refunded"}`)
+	st.Expect(t, code, http.StatusForbidden)
+	st.Expect(t, body, `{"error":"Guard failed from paid to refunded: refund window closed"}`)
+
+	// operational failures aren't denials
+	code, body = do(http.MethodPost, "/transition", `{"to":"shipped"}`)
+	st.Expect(t, code, http.StatusInternalServerError)
+	st.Expect(t, body, `{"error":"Guard failed from paid to shipped: carrier down"}`)
+	code, _ = do(http.MethodPost, "/transition", `{"to":"pending"}`)
+	st.Expect(t, code, http.StatusInternalServerError)
+
+	code, _ = do(http.MethodPost, "/transition", `{}`)
+	st.Expect(t, code, http.StatusBadRequest)
+	code, _ = do(http.MethodGet, "/transition", "")
+	st.Expect(t, code, http.StatusMethodNotAllowed)
+	code, _ = do(http.MethodPost, "/state", "")
+	st.Expect(t, code, http.StatusMethodNotAllowed)
+}
+
+func TestHandlerConcurrentTransitions(t *testing.T) {
+	pending := fsm.NewState(fsm.String("pending"))
+	paid := fsm.NewState(fsm.String("paid"))
+	rules := fsm.CreateRuleset(fsm.NewTransition(pending, paid), fsm.NewTransition(paid, pending))
+	h := fsmhttp.NewHandler(fsm.New(fsm.WithInitialState(pending), fsm.WithRules(&rules)))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		goal := []string{"pending", "paid"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/transition", strings.NewReader(`{"to":"`+goal+`"}`)))
+			// the state answered is the one entered by this request
+			if w.Code == http.StatusOK {
+				st.Expect(t, strings.TrimSpace(w.Body.String()), `{"state":"`+goal+`"}`)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestHandlerPredicateRules(t *testing.T) {
+	pending := fsm.NewState(fsm.String("pending"))
+	rules := fsm.Ruleset{}
+	rules.AddRuleFunc(pending, func(goal fsm.State) bool {
+		return strings.HasPrefix(goal.String(), "error_")
+	}, func(start *fsm.State, goal *fsm.State) error { return nil })
+	h := fsmhttp.NewHandler(fsm.New(fsm.WithInitialState(pending), fsm.WithRules(&rules), fsm.WithStrictStates()))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/transition", strings.NewReader(`{"to":"archived"}`)))
+	st.Expect(t, w.Code, http.StatusNotFound)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/transition", strings.NewReader(`{"to":"error_x"}`)))
+	st.Expect(t, w.Code, http.StatusOK)
+	st.Expect(t, strings.TrimSpace(w.Body.String()), `{"state":"error_x"}`)
+}
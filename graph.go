@@ -0,0 +1,313 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	errUnreachableFormat     = "%w from %s: %v"
+	errLeavingTerminalFormat = "%w left by transitions: %v"
+	errNoPathFormat          = "%w from %s to %s"
+)
+
+var (
+	// ErrUnreachableStates is returned by Validate when some states can't
+	// be reached from the start state
+	ErrUnreachableStates = errors.New("unreachable states")
+	// ErrNoPath is returned by Path when the goal can't be reached
+	ErrNoPath = errors.New("no path")
+)
+
+// Validation describes the structure problems of a ruleset, see Validate
+type Validation struct {
+	// Unreachable lists the states which can't be reached from the start state
+	Unreachable []ID
+	// DeadEnds lists the states which have no transition to any other state,
+	// which is expected for final states
+	DeadEnds []ID
+	// LeavingTerminal lists the terminal states being the origin of
+	// transitions, see MarkTerminal; transitions from AnyState don't count
+	LeavingTerminal []ID
+}
+
+// Validate checks the structure of the ruleset from the start state; only
+// transitions are considered, guards aren't run. An error wrapping
+// ErrUnreachableStates is returned if some states can't be reached,
+// else an error wrapping ErrTerminalState if terminal states have
+// transitions leaving them. The lists of the Validation are sorted.
+func (r *Ruleset) Validate(start IDer) (Validation, error) {
+	adjacency := r.adjacency()
+	if _, ok := adjacency[start.ID()]; !ok {
+		adjacency[start.ID()] = nil
+	}
+
+	reached := map[ID]bool{start.ID(): true}
+	queue := []ID{start.ID()}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, next := range adjacency[id] {
+			if !reached[next] {
+				reached[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	v := Validation{}
+	for id, exits := range adjacency {
+		if !reached[id] {
+			v.Unreachable = append(v.Unreachable, id)
+		}
+		if len(exits) == 0 {
+			v.DeadEnds = append(v.DeadEnds, id)
+		}
+	}
+	leaving := map[ID]bool{}
+	for t := range r.rules {
+		if r.terminal[t.O] && !leaving[t.O] {
+			leaving[t.O] = true
+			v.LeavingTerminal = append(v.LeavingTerminal, t.O)
+		}
+	}
+	sortIDs(v.Unreachable)
+	sortIDs(v.DeadEnds)
+	sortIDs(v.LeavingTerminal)
+
+	if len(v.Unreachable) > 0 {
+		return v, fmt.Errorf(errUnreachableFormat, ErrUnreachableStates, start.ID(), v.Unreachable)
+	}
+	if len(v.LeavingTerminal) > 0 {
+		return v, fmt.Errorf(errLeavingTerminalFormat, ErrTerminalState, v.LeavingTerminal)
+	}
+	return v, nil
+}
+
+// Path returns the shortest sequence of states leading from the start to
+// the goal, the start excluded, so that it can be given to TransitionPath.
+// Only transitions are considered, guards aren't run; the intermediate
+// states only carry their ID (see IDState). When several paths are as
+// short the first by sorted IDs is returned. An error wrapping ErrNoPath
+// is returned if the goal can't be reached, an empty path if it is the
+// start.
+func (r *Ruleset) Path(start, goal State) ([]State, error) {
+	if start.ID() == goal.ID() {
+		return []State{}, nil
+	}
+	adjacency := r.adjacency()
+	if _, ok := adjacency[start.ID()]; !ok {
+		for _, t := range r.sortedTransitions() {
+			if t.O == AnyState.ID() && t.E != start.ID() {
+				adjacency[start.ID()] = append(adjacency[start.ID()], t.E)
+			}
+		}
+	}
+
+	previous := map[ID]ID{start.ID(): nil}
+	queue := []ID{start.ID()}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, next := range adjacency[id] {
+			if _, ok := previous[next]; ok {
+				continue
+			}
+			previous[next] = id
+			if next != goal.ID() {
+				queue = append(queue, next)
+				continue
+			}
+
+			path := []State{goal}
+			for id := previous[next]; id != start.ID(); id = previous[id] {
+				path = append(path, IDState(id))
+			}
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path, nil
+		}
+	}
+	return nil, fmt.Errorf(errNoPathFormat, ErrNoPath, start, goal)
+}
+
+// States returns the states of the rules of the machine, see
+// Ruleset.States, reading them with the machine locked. It returns nil if
+// the machine has no rules.
+func (m *Machine) States() []ID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Rules == nil {
+		return nil
+	}
+	return m.Rules.States()
+}
+
+// States returns the sorted IDs of every state used as origin or exit of
+// a transition, AnyState isn't one of them
+func (r *Ruleset) States() []ID {
+	seen := map[ID]bool{AnyState.ID(): true}
+	ids := []ID{}
+	for t := range r.rules {
+		for _, id := range []ID{t.O, t.E} {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sortIDs(ids)
+	return ids
+}
+
+// StateNamed returns the state of the rules whose ID has the given string
+// form (see State.String), and whether there is one, e.g. to get back the
+// ID of a state decoded by State.UnmarshalJSON or State.Scan when the rules
+// don't use String IDs. As rules only know about IDs, the state only
+// carries its ID, see IDState.
+func (r *Ruleset) StateNamed(name string) (State, bool) {
+	for _, id := range r.States() {
+		if fmt.Sprint(id) == name {
+			return IDState(id), true
+		}
+	}
+	return State{}, false
+}
+
+// hasState reports whether id is used as origin or exit of a transition
+func (r *Ruleset) hasState(id ID) bool {
+	for t := range r.rules {
+		if t.O == id || t.E == id {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRule reports whether the ruleset has a rule for exactly t, without
+// running its guards. Unlike Permitted it doesn't fall back on a rule
+// from AnyState, which has to be asked for explicitly.
+func (r *Ruleset) HasRule(t Transition) bool {
+	_, ok := r.rules[key(t)]
+	return ok
+}
+
+// GuardCount returns the number of guards of the rule for exactly t, 0 if
+// there is none, without running them. The default guard added by
+// AddTransition is counted, origin, exit and global guards aren't.
+func (r *Ruleset) GuardCount(t Transition) int {
+	return len(r.rules[key(t)])
+}
+
+// StructurallyEqual reports whether r and other have the same transitions
+// with the same number of guards, see GuardCount. Guards are compared by
+// count only, guard functions can't be compared.
+func (r *Ruleset) StructurallyEqual(other Ruleset) bool {
+	if len(r.rules) != len(other.rules) {
+		return false
+	}
+	for t, guards := range r.rules {
+		if others, ok := other.rules[t]; !ok || len(others) != len(guards) {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff returns the transitions going from r to other: added are the ones
+// only other has, removed the ones only r has, both ordered like
+// Transitions. Guards aren't compared, see StructurallyEqual.
+func (r *Ruleset) Diff(other Ruleset) (added, removed []Transition) {
+	for _, t := range other.sortedTransitions() {
+		if _, ok := r.rules[t]; !ok {
+			added = append(added, t)
+		}
+	}
+	for _, t := range r.sortedTransitions() {
+		if _, ok := other.rules[t]; !ok {
+			removed = append(removed, t)
+		}
+	}
+	return added, removed
+}
+
+// OriginsFor returns the sorted IDs of the origins having a transition to
+// the goal, guards aren't run. AnyState's ID is part of them when the goal
+// can be reached from any state.
+func (r *Ruleset) OriginsFor(goal IDer) []ID {
+	ids := []ID{}
+	for t := range r.rules {
+		if t.E == goal.ID() {
+			ids = append(ids, t.O)
+		}
+	}
+	sortIDs(ids)
+	return ids
+}
+
+// adjacency returns, for every state found in the transitions, the states
+// it has a transition to, ignoring self transitions. Transitions from
+// AnyState lead from every other state to their exit, and AnyState itself
+// isn't listed.
+func (r *Ruleset) adjacency() map[ID][]ID {
+	adjacency := map[ID][]ID{}
+	var wildcards []ID
+	for _, t := range r.sortedTransitions() {
+		if _, ok := adjacency[t.Exit()]; !ok {
+			adjacency[t.Exit()] = nil
+		}
+		if t.Origin() == AnyState.ID() {
+			wildcards = append(wildcards, t.Exit())
+			continue
+		}
+		if t.Origin() != t.Exit() {
+			adjacency[t.Origin()] = append(adjacency[t.Origin()], t.Exit())
+		} else if _, ok := adjacency[t.Origin()]; !ok {
+			adjacency[t.Origin()] = nil
+		}
+	}
+	for id := range adjacency {
+		for _, exit := range wildcards {
+			if id != exit {
+				adjacency[id] = append(adjacency[id], exit)
+			}
+		}
+	}
+	for id := range adjacency {
+		sortIDs(adjacency[id])
+	}
+	return adjacency
+}
+
+// PermittedGraph returns, for every state of the rules (see States), the
+// states it can reach as returned by AvailableTransitions if the machine
+// was at it, checked the way the machine does (see CanTransition). The
+// guards are run against probe states only carrying the ID (see IDState),
+// never against the state of the machine: guards depending on the data of
+// the state or having side effects give results based on the ID only.
+func (m *Machine) PermittedGraph() map[ID][]ID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	graph := map[ID][]ID{}
+	if m.Rules == nil {
+		return graph
+	}
+	ctx := context.Background()
+	start := m.State
+	defer func() {
+		m.State = start
+		m.eval.cache.clear()
+	}()
+	for _, id := range m.Rules.States() {
+		m.State = IDState(id)
+		m.eval.cache.clear()
+		graph[id] = m.Rules.permittedFrom(&m.State, func(goal State) bool {
+			return m.permits(ctx, goal) == nil
+		})
+	}
+	return graph
+}
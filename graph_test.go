@@ -0,0 +1,268 @@
+package fsm_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestRulesetValidateDiamond(t *testing.T) {
+	a, b, c, d := fsm.String("a"), fsm.String("b"), fsm.String("c"), fsm.String("d")
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(a, b),
+		fsm.NewTransition(a, c),
+		fsm.NewTransition(b, d),
+		fsm.NewTransition(c, d),
+	)
+
+	v, err := rules.Validate(a)
+	st.Expect(t, err, nil)
+	st.Expect(t, len(v.Unreachable), 0)
+	st.Expect(t, v.DeadEnds, []fsm.ID{d})
+
+	// from the middle, the rest of the diamond is unreachable
+	v, err = rules.Validate(b)
+	st.Expect(t, errors.Is(err, fsm.ErrUnreachableStates), true)
+	st.Expect(t, v.Unreachable, []fsm.ID{a, c})
+}
+
+func TestRulesetValidateIsland(t *testing.T) {
+	a, b, x, y := fsm.String("a"), fsm.String("b"), fsm.String("x"), fsm.String("y")
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(a, b),
+		fsm.NewTransition(x, y),
+		fsm.NewTransition(y, x),
+	)
+
+	v, err := rules.Validate(a)
+	st.Expect(t, err.Error(), "unreachable states from a: [x y]")
+	st.Expect(t, v.Unreachable, []fsm.ID{x, y})
+	st.Expect(t, v.DeadEnds, []fsm.ID{b})
+}
+
+func TestRulesetValidateConnected(t *testing.T) {
+	states := []fsm.String{"a", "b", "c"}
+	rules := fsm.Ruleset{}
+	for _, from := range states {
+		for _, to := range states {
+			rules.AddTransition(fsm.NewTransition(from, to))
+		}
+	}
+
+	v, err := rules.Validate(states[2])
+	st.Expect(t, err, nil)
+	st.Expect(t, len(v.Unreachable), 0)
+	st.Expect(t, len(v.DeadEnds), 0)
+}
+
+func TestRulesetValidateAnyState(t *testing.T) {
+	a, b, cancelled := fsm.String("a"), fsm.String("b"), fsm.String("cancelled")
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(a, b),
+		fsm.NewTransition(fsm.AnyState, cancelled),
+	)
+
+	v, err := rules.Validate(a)
+	st.Expect(t, err, nil)
+	st.Expect(t, v.DeadEnds, []fsm.ID{cancelled})
+}
+
+func TestRulesetStates(t *testing.T) {
+	a, b, c := fsm.String("a"), fsm.String("b"), fsm.String("c")
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(b, c),
+		fsm.NewTransition(a, b),
+		fsm.NewTransition(c, a),
+		fsm.NewTransition(a, c),
+		fsm.NewTransition(fsm.AnyState, c),
+	)
+
+	st.Expect(t, rules.States(), []fsm.ID{a, b, c})
+	st.Expect(t, (&fsm.Ruleset{}).States(), []fsm.ID{})
+}
+
+func TestRulesetOriginsFor(t *testing.T) {
+	a, b, c, shipped := fsm.String("a"), fsm.String("b"), fsm.String("c"), fsm.String("shipped")
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(c, shipped),
+		fsm.NewTransition(a, shipped),
+		fsm.NewTransition(b, shipped),
+		fsm.NewTransition(a, b),
+	)
+
+	st.Expect(t, rules.OriginsFor(shipped), []fsm.ID{a, b, c})
+	st.Expect(t, rules.OriginsFor(b), []fsm.ID{a})
+	st.Expect(t, rules.OriginsFor(a), []fsm.ID{})
+
+	rules.AddTransition(fsm.NewTransition(fsm.AnyState, a))
+	st.Expect(t, rules.OriginsFor(a), []fsm.ID{fsm.AnyState.ID()})
+}
+
+func TestRulesetHasRule(t *testing.T) {
+	a, b := fsm.String("a"), fsm.String("b")
+	rules := fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(a, b), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+
+	st.Expect(t, rules.HasRule(fsm.NewTransition(a, b)), true)
+	st.Expect(t, rules.HasRule(fsm.NewTransition(b, a)), false)
+
+	rules.AddTransition(fsm.NewTransition(fsm.AnyState, a))
+	st.Expect(t, rules.HasRule(fsm.NewTransition(b, a)), false)
+	st.Expect(t, rules.HasRule(fsm.NewTransition(fsm.AnyState, a)), true)
+
+	rules.RemoveRule(fsm.NewTransition(a, b))
+	st.Expect(t, rules.HasRule(fsm.NewTransition(a, b)), false)
+}
+
+func TestRulesetGuardCount(t *testing.T) {
+	a, b := fsm.String("a"), fsm.String("b")
+	guard := func(start *fsm.State, goal *fsm.State) error { return nil }
+	rules := fsm.Ruleset{}
+	rules.AddGlobalGuard(guard)
+	st.Expect(t, rules.GuardCount(fsm.NewTransition(a, b)), 0)
+
+	rules.AddRule(fsm.NewTransition(a, b), guard)
+	rules.AddRule(fsm.NewTransition(a, b), guard, guard)
+	st.Expect(t, rules.GuardCount(fsm.NewTransition(a, b)), 3)
+	st.Expect(t, rules.GuardCount(fsm.NewTransition(b, a)), 0)
+
+	rules.AddTransition(fsm.NewTransition(b, a))
+	st.Expect(t, rules.GuardCount(fsm.NewTransition(b, a)), 1)
+}
+
+func TestRulesetPath(t *testing.T) {
+	draft := fsm.NewState(fsm.String("draft"))
+	review := fsm.NewState(fsm.String("review"))
+	published := fsm.NewState(fsm.String("published"))
+	archived := fsm.NewState(fsm.String("archived"))
+	orphan := fsm.NewState(fsm.String("orphan"))
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(draft, review),
+		fsm.NewTransition(review, published),
+		fsm.NewTransition(published, archived),
+		fsm.NewTransition(review, archived),
+		fsm.NewTransition(orphan, draft),
+	)
+
+	path, err := rules.Path(draft, archived)
+	st.Expect(t, err, nil)
+	st.Expect(t, len(path), 2)
+	st.Expect(t, path[0].ID(), review.ID())
+	st.Expect(t, path[1], archived)
+
+	path, err = rules.Path(draft, draft)
+	st.Expect(t, err, nil)
+	st.Expect(t, path, []fsm.State{})
+
+	_, err = rules.Path(draft, orphan)
+	st.Expect(t, errors.Is(err, fsm.ErrNoPath), true)
+	st.Expect(t, err.Error(), "no path from draft to orphan")
+
+	// following the path is permitted when guards pass
+	path, _ = rules.Path(draft, archived)
+	m := fsm.New(fsm.WithInitialState(draft))
+	m.Rules = &rules
+	st.Expect(t, m.TransitionPath(path...), nil)
+	st.Expect(t, m.Current(), archived)
+
+	// wildcard transitions are shortcuts
+	rules.AddTransition(fsm.NewTransition(fsm.AnyState, archived))
+	path, err = rules.Path(orphan, archived)
+	st.Expect(t, err, nil)
+	st.Expect(t, path, []fsm.State{archived})
+}
+
+func TestRulesetStructurallyEqual(t *testing.T) {
+	guard := func(start *fsm.State, goal *fsm.State) error { return nil }
+	build := func() fsm.Ruleset {
+		rules := fsm.CreateRuleset(
+			fsm.NewTransition(statePending, stateStarted),
+			fsm.NewTransition(stateStarted, stateFinished),
+		)
+		rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), guard)
+		return rules
+	}
+
+	rules, same := build(), build()
+	st.Expect(t, rules.StructurallyEqual(same), true)
+	added, removed := rules.Diff(same)
+	st.Expect(t, added, []fsm.Transition(nil))
+	st.Expect(t, removed, []fsm.Transition(nil))
+
+	// guard counts matter
+	same.AddRule(fsm.NewTransition(statePending, stateStarted), guard)
+	st.Expect(t, rules.StructurallyEqual(same), false)
+
+	superset := build()
+	superset.AddTransition(fsm.NewTransition(stateFinished, statePending))
+	st.Expect(t, rules.StructurallyEqual(superset), false)
+	st.Expect(t, superset.StructurallyEqual(rules), false)
+	added, removed = rules.Diff(superset)
+	st.Expect(t, added, []fsm.Transition{fsm.NewTransition(stateFinished, statePending)})
+	st.Expect(t, removed, []fsm.Transition(nil))
+
+	disjoint := fsm.CreateRuleset(fsm.NewTransition(stateFinished, statePending))
+	st.Expect(t, rules.StructurallyEqual(disjoint), false)
+	added, removed = rules.Diff(disjoint)
+	st.Expect(t, added, []fsm.Transition{fsm.NewTransition(stateFinished, statePending)})
+	st.Expect(t, removed, []fsm.Transition{
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+	})
+}
+
+func TestMachinePermittedGraph(t *testing.T) {
+	a, b, c := fsm.String("a"), fsm.String("b"), fsm.String("c")
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(a, b),
+		fsm.NewTransition(a, c),
+		fsm.NewTransition(b, c),
+	)
+	rules.AddRule(fsm.NewTransition(a, c), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+
+	m := fsm.New(fsm.WithInitialState(fsm.NewState(b)))
+	m.Rules = &rules
+	st.Expect(t, m.PermittedGraph(), map[fsm.ID][]fsm.ID{
+		a: {b},
+		b: {c},
+		c: {},
+	})
+	st.Expect(t, m.Current(), fsm.NewState(b))
+
+	st.Expect(t, fsm.New().PermittedGraph(), map[fsm.ID][]fsm.ID{})
+
+	// the machine's evaluation applies
+	open := fsm.Ruleset{}
+	open.AddRule(fsm.NewTransition(a, b))
+	m = fsm.New(fsm.WithInitialState(fsm.NewState(a)), fsm.WithRules(&open))
+	st.Expect(t, m.PermittedGraph(), map[fsm.ID][]fsm.ID{a: {}, b: {}})
+	m = fsm.New(fsm.WithInitialState(fsm.NewState(a)), fsm.WithRules(&open), fsm.WithEmptyGuardPolicy(fsm.PolicyOpen))
+	st.Expect(t, m.PermittedGraph(), map[fsm.ID][]fsm.ID{a: {b}, b: {}})
+	st.Expect(t, m.AvailableTransitions(), []fsm.ID{b})
+}
+
+func TestRulesetTransitionsOrder(t *testing.T) {
+	var ts []fsm.Transition
+	for i := 0; i < 20; i++ {
+		ts = append(ts, fsm.NewTransition(fsm.String(fmt.Sprintf("s%02d", i%7)), fsm.String(fmt.Sprintf("s%02d", i))))
+	}
+	rules := fsm.CreateRuleset(ts...)
+	rules.AddTransition(fsm.NewTransition(fsm.AnyState, fsm.String("s00")))
+
+	first := rules.Transitions()
+	st.Expect(t, len(first), 21)
+	st.Expect(t, first[0], fsm.Transition(fsm.NewTransition(fsm.AnyState, fsm.String("s00"))))
+	st.Expect(t, first[1], fsm.Transition(fsm.NewTransition(fsm.String("s00"), fsm.String("s00"))))
+	st.Expect(t, first[2], fsm.Transition(fsm.NewTransition(fsm.String("s00"), fsm.String("s07"))))
+	for i := 0; i < 100; i++ {
+		st.Expect(t, rules.Transitions(), first)
+	}
+	st.Expect(t, (&fsm.Ruleset{}).Transitions(), []fsm.Transition{})
+}
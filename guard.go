@@ -0,0 +1,136 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrDenied is matched by the errors of guards reporting a plain denial,
+	// as opposed to an operational failure, see Deny
+	ErrDenied = errors.New("denied")
+	// ErrNegatedGuard is returned by a Not guard when the guard it negates
+	// passes, it is a denial
+	ErrNegatedGuard = Deny("negated guard passed")
+	// Allow is returned by a guard to permit the transition right away,
+	// without running the guards after it, e.g. for an override such as
+	// a superadmin. Guards thus have three outcomes: nil defers to the
+	// other guards, Allow permits and any other error denies.
+	Allow = errors.New("allowed")
+)
+
+const (
+	errWindowFormat = "not within %s and %s"
+	errAfterFormat  = "not before %s"
+)
+
+// Now returns the current time as seen by the time based guards,
+// WithinWindow and After. It may be replaced, e.g. by tests.
+var Now = time.Now
+
+// denial is the error returned by Deny
+type denial struct{ reason string }
+
+func (d *denial) Error() string { return d.reason }
+
+func (d *denial) Is(target error) bool { return target == ErrDenied }
+
+// Deny returns an error for guards to report that the transition simply
+// isn't permitted, which errors.Is matches with ErrDenied. Other guard
+// errors are considered operational failures (e.g. a database being
+// down) by TryTransition.
+func Deny(reason string) error {
+	return &denial{reason}
+}
+
+// And combines guards into one which passes only if all of them pass.
+// Guards are run in order and the first error is returned, the
+// remaining guards are not run.
+func And(guards ...Guard) Guard {
+	return func(start *State, goal *State) error {
+		for _, guard := range guards {
+			if err := guard(start, goal); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Or combines guards into one which passes as soon as one of them passes,
+// the remaining guards are not run. A guard returning Allow makes Or return
+// Allow, permitting the transition as it would outside of Or. If they all
+// fail the error of the last one is returned, Or without any guard always
+// fails with ErrInvalidTransition.
+func Or(guards ...Guard) Guard {
+	return func(start *State, goal *State) error {
+		err := ErrInvalidTransition
+		for _, guard := range guards {
+			if err = guard(start, goal); err == nil || errors.Is(err, Allow) {
+				return err
+			}
+		}
+		return err
+	}
+}
+
+// Not negates a guard: it passes when g denies the transition with an
+// error matching ErrDenied (see Deny), and fails with ErrNegatedGuard when g
+// passes, including when it returns Allow. Other errors of g are
+// operational failures and are returned as is, e.g. a database being down
+// doesn't permit the transition.
+func Not(g Guard) Guard {
+	return func(start *State, goal *State) error {
+		err := g(start, goal)
+		if err == nil || errors.Is(err, Allow) {
+			return ErrNegatedGuard
+		}
+		if errors.Is(err, ErrDenied) {
+			return nil
+		}
+		return err
+	}
+}
+
+// GuardFactory returns the guard to run for a transition, see Lazy
+type GuardFactory func() Guard
+
+// Lazy returns a guard asking f for the guard to run every time it is
+// evaluated, e.g. to follow a feature flag without changing the rules.
+// Like guards, f may be called from many goroutines at once and must be
+// safe for concurrent use, e.g. reading an atomic.Value. A nil guard
+// returned by f passes.
+func Lazy(f GuardFactory) Guard {
+	return func(start *State, goal *State) error {
+		if g := f(); g != nil {
+			return g(start, goal)
+		}
+		return nil
+	}
+}
+
+// WithinWindow returns a guard which passes from start, included, until
+// end, excluded, and denies the transition otherwise
+func WithinWindow(start, end time.Time) Guard {
+	return func(s *State, goal *State) error {
+		now := Now()
+		if now.Before(start) || !now.Before(end) {
+			return Deny(fmt.Sprintf(errWindowFormat, start, end))
+		}
+		return nil
+	}
+}
+
+// After returns a guard which passes once d elapsed since the time
+// returned by at for the start state (e.g. a creation date carried by the
+// state's data), included, and denies the transition before. For
+// transitions permitted only within d of that time, use Not(After(at, d)).
+func After(at func(start *State) time.Time, d time.Duration) Guard {
+	return func(start *State, goal *State) error {
+		if t := at(start).Add(d); Now().Before(t) {
+			return Deny(fmt.Sprintf(errAfterFormat, t))
+		}
+		return nil
+	}
+}
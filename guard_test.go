@@ -0,0 +1,170 @@
+package fsm_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+// countingGuard returns a guard returning err and counting its calls
+func countingGuard(err error, calls *int) fsm.Guard {
+	return func(start *fsm.State, goal *fsm.State) error {
+		*calls++
+		return err
+	}
+}
+
+func TestGuardAnd(t *testing.T) {
+	var first, second int
+	g := fsm.And(countingGuard(testError, &first), countingGuard(nil, &second))
+	st.Expect(t, g(&statePending, &stateStarted), testError)
+	st.Expect(t, first, 1)
+	st.Expect(t, second, 0)
+
+	g = fsm.And(countingGuard(nil, &first), countingGuard(nil, &second))
+	st.Expect(t, g(&statePending, &stateStarted), nil)
+	st.Expect(t, second, 1)
+
+	st.Expect(t, fsm.And()(&statePending, &stateStarted), nil)
+}
+
+func TestGuardOr(t *testing.T) {
+	var first, second int
+	g := fsm.Or(countingGuard(nil, &first), countingGuard(testError, &second))
+	st.Expect(t, g(&statePending, &stateStarted), nil)
+	st.Expect(t, first, 1)
+	st.Expect(t, second, 0)
+
+	other := errors.New("other error")
+	g = fsm.Or(countingGuard(testError, &first), countingGuard(other, &second))
+	st.Expect(t, g(&statePending, &stateStarted), other)
+	st.Expect(t, second, 1)
+
+	st.Expect(t, fsm.Or()(&statePending, &stateStarted), fsm.ErrInvalidTransition)
+
+	g = fsm.Or(countingGuard(testError, &first), countingGuard(fsm.Allow, &first), countingGuard(other, &second))
+	st.Expect(t, g(&statePending, &stateStarted), fsm.Allow)
+	st.Expect(t, second, 1)
+}
+
+func TestGuardNot(t *testing.T) {
+	var calls int
+	st.Expect(t, fsm.Not(countingGuard(fsm.Deny("not paid"), &calls))(&statePending, &stateStarted), nil)
+	st.Expect(t, fsm.Not(countingGuard(nil, &calls))(&statePending, &stateStarted), fsm.ErrNegatedGuard)
+	st.Expect(t, fsm.Not(countingGuard(fsm.Allow, &calls))(&statePending, &stateStarted), fsm.ErrNegatedGuard)
+	st.Expect(t, calls, 3)
+
+	// operational failures still deny
+	errDatabase := errors.New("database is down")
+	dbDown := func(start *fsm.State, goal *fsm.State) error { return errDatabase }
+	st.Expect(t, fsm.Not(dbDown)(&statePending, &stateStarted), errDatabase)
+
+	rules := fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), fsm.Or(fsm.Not(countingGuard(nil, &calls)), countingGuard(nil, &calls)))
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+}
+
+func TestGuardLazy(t *testing.T) {
+	var current atomic.Value
+	current.Store(fsm.Guard(func(start *fsm.State, goal *fsm.State) error {
+		return nil
+	}))
+	rules := fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), fsm.Lazy(func() fsm.Guard {
+		return current.Load().(fsm.Guard)
+	}))
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+
+	current.Store(fsm.Guard(func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	}))
+	st.Expect(t, errors.Is(rules.Permitted(&statePending, &stateStarted), testError), true)
+
+	st.Expect(t, fsm.Lazy(func() fsm.Guard { return nil })(&statePending, &stateStarted), nil)
+}
+
+func TestGuardAllow(t *testing.T) {
+	admin := true
+	var denied int
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		if admin {
+			return fsm.Allow
+		}
+		return nil
+	}, countingGuard(fsm.Deny("closed"), &denied))
+	rules.AddGlobalGuard(countingGuard(fsm.Deny("maintenance"), &denied))
+
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	st.Expect(t, denied, 0)
+	st.Expect(t, len(rules.PermittedDetailed(&statePending, &stateStarted)), 0)
+	st.Expect(t, denied, 0)
+
+	m := fsm.New(fsm.WithGuardConcurrency(1), fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, denied, 0)
+
+	admin = false
+	st.Expect(t, errors.Is(rules.Permitted(&statePending, &stateStarted), fsm.ErrDenied), true)
+	st.Expect(t, denied, 1)
+
+	// guards before Allow must have passed
+	rules = fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), countingGuard(testError, &denied), countingGuard(fsm.Allow, &denied))
+	st.Expect(t, errors.Is(rules.Permitted(&statePending, &stateStarted), testError), true)
+	st.Expect(t, len(rules.PermittedDetailed(&statePending, &stateStarted)), 1)
+}
+
+func TestGuardWithinWindow(t *testing.T) {
+	defer func() { fsm.Now = time.Now }()
+	start := time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(8 * time.Hour)
+	guard := fsm.WithinWindow(start, end)
+
+	for _, c := range []struct {
+		now time.Time
+		ok  bool
+	}{
+		{start.Add(-time.Nanosecond), false},
+		{start, true},
+		{start.Add(time.Hour), true},
+		{end.Add(-time.Nanosecond), true},
+		{end, false},
+	} {
+		fsm.Now = func() time.Time { return c.now }
+		err := guard(&statePending, &stateStarted)
+		st.Expect(t, err == nil, c.ok)
+		if err != nil {
+			st.Expect(t, errors.Is(err, fsm.ErrDenied), true)
+		}
+	}
+}
+
+// invoice is pending state data carrying its creation date
+type invoice struct{ created time.Time }
+
+func (i invoice) ID() fsm.ID { return "pending" }
+
+func TestGuardAfter(t *testing.T) {
+	defer func() { fsm.Now = time.Now }()
+	created := time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)
+	at := func(start *fsm.State) time.Time { return start.I.(invoice).created }
+	pending := fsm.NewState(invoice{created})
+
+	rules := fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(pending, stateStarted), fsm.After(at, time.Hour))
+	rules.AddRule(fsm.NewTransition(pending, stateFinished), fsm.Not(fsm.After(at, time.Hour)))
+
+	fsm.Now = func() time.Time { return created.Add(time.Hour - time.Nanosecond) }
+	st.Expect(t, errors.Is(rules.Permitted(&pending, &stateStarted), fsm.ErrDenied), true)
+	st.Expect(t, rules.Permitted(&pending, &stateFinished), nil)
+
+	fsm.Now = func() time.Time { return created.Add(time.Hour) }
+	st.Expect(t, rules.Permitted(&pending, &stateStarted), nil)
+	st.Expect(t, errors.Is(rules.Permitted(&pending, &stateFinished), fsm.ErrDenied), true)
+}
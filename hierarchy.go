@@ -0,0 +1,90 @@
+package fsm
+
+import "context"
+
+// rulesetKey is the context key under which the ruleset running the guards
+// is stored, see RulesetFrom
+type rulesetKey struct{}
+
+// SetParent declares child nested in parent (e.g. "validating" and
+// "charging" within "processing"): when no rule exists from the child to
+// a goal, the rules from its parent, then from the parent of its parent
+// and so on, apply to it before the one from AnyState. The origin guards
+// run are the ones of the child. A state has at most one parent, setting
+// another one replaces it.
+func (r *Ruleset) SetParent(child IDer, parent IDer) {
+	if r.parents == nil {
+		r.parents = map[ID]ID{}
+	}
+	r.parents[child.ID()] = parent.ID()
+}
+
+// Parent returns the parent of the state, see SetParent
+func (r *Ruleset) Parent(child IDer) (ID, bool) {
+	parent, ok := r.parents[child.ID()]
+	return parent, ok
+}
+
+// withRuleset returns ctx carrying r, see RulesetFrom
+func (r *Ruleset) withRuleset(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rulesetKey{}, r)
+}
+
+// RulesetFrom returns the ruleset running the guards from the context given
+// to a GuardCtx, e.g. for a guard to only permit leaving a state having a
+// way back (see HasRule). Guards may inspect the ruleset but must not
+// change it while it is in use, and a guard calling Permitted for its own
+// transition recurses forever.
+func RulesetFrom(ctx context.Context) (*Ruleset, bool) {
+	r, ok := ctx.Value(rulesetKey{}).(*Ruleset)
+	return r, ok
+}
+
+// ruleFor returns the key of the rule applying to a transition from start
+// to exit: the exact one, else the one of the closest ancestor of start,
+// else the one from AnyState
+func (r *Ruleset) ruleFor(start ID, exit ID) (T, bool) {
+	if _, ok := r.rules[T{start, exit}]; ok {
+		return T{start, exit}, true
+	}
+	for _, ancestor := range r.ancestors(start) {
+		if _, ok := r.rules[T{ancestor, exit}]; ok {
+			return T{ancestor, exit}, true
+		}
+	}
+	if _, ok := r.rules[T{AnyState.ID(), exit}]; ok {
+		return T{AnyState.ID(), exit}, true
+	}
+	return T{}, false
+}
+
+// ancestors returns the parent of id, its parent and so on, stopping on
+// cycles
+func (r *Ruleset) ancestors(id ID) []ID {
+	var ids []ID
+	seen := map[ID]bool{id: true}
+	for {
+		parent, ok := r.parents[id]
+		if !ok || seen[parent] {
+			return ids
+		}
+		seen[parent] = true
+		ids = append(ids, parent)
+		id = parent
+	}
+}
+
+// descends reports whether ancestor is one of the ancestors of id, when
+// ctx carries a ruleset
+func descends(ctx context.Context, id ID, ancestor ID) bool {
+	r, ok := RulesetFrom(ctx)
+	if !ok {
+		return false
+	}
+	for _, a := range r.ancestors(id) {
+		if a == ancestor {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package fsm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestRulesetSetParent(t *testing.T) {
+	processing := fsm.NewState(fsm.String("processing"))
+	validating := fsm.NewState(fsm.String("validating"))
+	charging := fsm.NewState(fsm.String("charging"))
+	cancelled := fsm.NewState(fsm.String("cancelled"))
+
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(validating, charging),
+		fsm.NewTransition(processing, cancelled),
+		fsm.NewTransition(charging, stateFinished),
+	)
+	st.Reject(t, rules.Permitted(&validating, &cancelled), nil)
+
+	// two levels: validating and charging are in processing, itself in
+	// pending
+	rules.SetParent(validating, processing)
+	rules.SetParent(charging, processing)
+	rules.SetParent(processing, statePending)
+	parent, ok := rules.Parent(validating)
+	st.Expect(t, parent, processing.ID())
+	st.Expect(t, ok, true)
+
+	st.Expect(t, rules.Permitted(&validating, &cancelled), nil)
+	st.Expect(t, rules.Permitted(&charging, &cancelled), nil)
+	st.Expect(t, rules.PermittedFrom(&validating), []fsm.ID{cancelled.ID(), charging.ID()})
+	st.Reject(t, rules.Permitted(&stateStarted, &cancelled), nil)
+
+	rules.AddTransition(fsm.NewTransition(statePending, stateStarted))
+	st.Expect(t, rules.Permitted(&charging, &stateStarted), nil)
+
+	// exact rules come first
+	rules.AddRule(fsm.NewTransition(charging, cancelled), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+	st.Expect(t, errors.Is(rules.Permitted(&charging, &cancelled), testError), true)
+	st.Expect(t, rules.Permitted(&validating, &cancelled), nil)
+
+	// parents are cloned
+	c := rules.Clone()
+	m := fsm.New(fsm.WithInitialState(validating))
+	m.Rules = &c
+	st.Expect(t, m.Transition(cancelled), nil)
+
+	// cycles don't loop forever
+	rules.SetParent(statePending, validating)
+	st.Reject(t, rules.Permitted(&validating, &stateFinished), nil)
+}
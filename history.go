@@ -0,0 +1,167 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	errReplayFromFormat = "%w: entry from %s while at %s"
+)
+
+var (
+	// ErrNoHistory is returned when rolling back a machine without any
+	// recorded transition
+	ErrNoHistory = errors.New("no history")
+	// ErrNoInitialState is returned when resetting a machine created
+	// without WithInitialState
+	ErrNoInitialState = errors.New("machine has no initial state")
+)
+
+// HistoryEntry records a successful transition of a Machine
+type HistoryEntry struct {
+	From State
+	To   State
+	Time time.Time
+}
+
+// history keeps the last transitions of a machine, a nil history
+// records nothing
+type history struct {
+	entries []HistoryEntry
+	// limit is the maximum number of entries kept, 0 for no limit
+	limit int
+}
+
+// record adds an entry, dropping the oldest one past the limit
+func (h *history) record(from, to State) {
+	if h == nil {
+		return
+	}
+	if h.limit > 0 && len(h.entries) == h.limit {
+		copy(h.entries, h.entries[1:])
+		h.entries = h.entries[:h.limit-1]
+	}
+	h.entries = append(h.entries, HistoryEntry{From: from, To: to, Time: time.Now()})
+}
+
+// WithHistory makes the machine record every successful transition,
+// see History
+func WithHistory() func(*Machine) {
+	return WithHistoryLimit(0)
+}
+
+// WithHistoryLimit is like WithHistory but only keeps the last n entries,
+// n <= 0 meaning no limit
+func WithHistoryLimit(n int) func(*Machine) {
+	if n < 0 {
+		n = 0
+	}
+	return func(m *Machine) {
+		m.history = &history{limit: n}
+	}
+}
+
+// History returns the recorded transitions, oldest first. It is empty
+// unless the machine was created with WithHistory or WithHistoryLimit.
+func (m *Machine) History() []HistoryEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.history == nil {
+		return []HistoryEntry{}
+	}
+	return append([]HistoryEntry{}, m.history.entries...)
+}
+
+// Rollback reverts the last recorded transition, moving the machine back to
+// the state it came from and dropping the entry from the history.
+// Rollback is an administrative revert: it bypasses the guards and runs no
+// callbacks, by design. ErrNoHistory is returned if there is nothing to
+// revert, including when history isn't enabled.
+func (m *Machine) Rollback() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.history == nil || len(m.history.entries) == 0 {
+		return ErrNoHistory
+	}
+	last := len(m.history.entries) - 1
+	m.State = m.history.entries[last].From
+	m.eval.cache.clear()
+	m.history.entries = m.history.entries[:last]
+	return nil
+}
+
+// Reset moves the machine back to the state set by WithInitialState and
+// clears the history, if enabled. Like Rollback it bypasses the guards and
+// runs no callbacks. ErrNoInitialState is returned if the machine has no
+// initial state.
+func (m *Machine) Reset() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.initial.I == nil {
+		return ErrNoInitialState
+	}
+	m.State = m.initial
+	m.eval.cache.clear()
+	if m.history != nil {
+		m.history.entries = nil
+	}
+	return nil
+}
+
+// Replay applies recorded transitions in order, e.g. to rebuild the state
+// of a fresh machine from a log, recording them in the history if enabled
+// with their original time. Like Restore it runs no callbacks. When verify
+// is true every entry must start from the state the machine is at, and be
+// permitted like Transition would (terminal states, interceptors and
+// guards, see CanTransition); a machine without state starts from the first
+// entry. On the first inconsistency the machine is left untouched and a
+// *PathError with the index of the entry is returned. Without verify the
+// entries are applied as they are.
+func (m *Machine) Replay(entries []HistoryEntry, verify bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start := m.State
+	var kept []HistoryEntry
+	if m.history != nil {
+		kept = append(kept, m.history.entries...)
+	}
+	m.eval.cache.clear()
+	for i, e := range entries {
+		if verify {
+			if err := m.verify(e); err != nil {
+				m.State = start
+				if m.history != nil {
+					m.history.entries = kept
+				}
+				return &PathError{Step: i, Err: err}
+			}
+		}
+		m.State = e.To
+		if m.history != nil {
+			m.history.record(e.From, e.To)
+			m.history.entries[len(m.history.entries)-1].Time = e.Time
+		}
+	}
+	return nil
+}
+
+// verify checks the entry can be replayed, the machine must be locked
+func (m *Machine) verify(e HistoryEntry) error {
+	if m.State.I == nil {
+		m.State = e.From
+	}
+	if err := m.precheck(e.To); err != nil {
+		return err
+	}
+	if m.State.ID() != e.From.ID() {
+		return fmt.Errorf(errReplayFromFormat, ErrInvalidTransition, stateID(e.From), m.State.ID())
+	}
+	return m.permits(context.Background(), e.To)
+}
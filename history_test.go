@@ -0,0 +1,169 @@
+package fsm_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+// historyStates returns the from and to IDs of the entries
+func historyStates(entries []fsm.HistoryEntry) [][2]fsm.ID {
+	states := [][2]fsm.ID{}
+	for _, e := range entries {
+		states = append(states, [2]fsm.ID{e.From.ID(), e.To.ID()})
+	}
+	return states
+}
+
+func TestMachineHistory(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+		fsm.NewTransition(stateFinished, statePending),
+	)
+
+	m := fsm.New(fsm.WithHistory())
+	m.State = statePending
+	m.Rules = &rules
+
+	begin := time.Now()
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Reject(t, m.Transition(statePending), nil)
+	st.Expect(t, m.Transition(stateFinished), nil)
+
+	h := m.History()
+	st.Expect(t, historyStates(h), [][2]fsm.ID{
+		{statePending.ID(), stateStarted.ID()},
+		{stateStarted.ID(), stateFinished.ID()},
+	})
+	st.Expect(t, h[0].Time.Before(begin), false)
+	st.Expect(t, h[1].Time.Before(h[0].Time), false)
+
+	// no history unless enabled
+	m = fsm.New()
+	m.State = statePending
+	m.Rules = &rules
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, m.History(), []fsm.HistoryEntry{})
+}
+
+func TestMachineHistoryLimit(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+		fsm.NewTransition(stateFinished, statePending),
+	)
+
+	m := fsm.New(fsm.WithHistoryLimit(2))
+	m.State = statePending
+	m.Rules = &rules
+
+	for _, goal := range []fsm.State{stateStarted, stateFinished, statePending} {
+		st.Expect(t, m.Transition(goal), nil)
+	}
+	st.Expect(t, historyStates(m.History()), [][2]fsm.ID{
+		{stateStarted.ID(), stateFinished.ID()},
+		{stateFinished.ID(), statePending.ID()},
+	})
+}
+
+func TestMachineRollback(t *testing.T) {
+	a, b, c := fsm.NewState(fsm.String("a")), fsm.NewState(fsm.String("b")), fsm.NewState(fsm.String("c"))
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(a, b),
+		fsm.NewTransition(b, c),
+	)
+
+	m := fsm.New(fsm.WithHistory())
+	m.State = a
+	m.Rules = &rules
+	st.Expect(t, m.Rollback(), fsm.ErrNoHistory)
+
+	st.Expect(t, m.Transition(b), nil)
+	st.Expect(t, m.Transition(c), nil)
+
+	// rolling back doesn't need a c -> b transition
+	st.Expect(t, m.Rollback(), nil)
+	st.Expect(t, m.Current(), b)
+	st.Expect(t, m.Rollback(), nil)
+	st.Expect(t, m.Current(), a)
+	st.Expect(t, len(m.History()), 0)
+	st.Expect(t, m.Rollback(), fsm.ErrNoHistory)
+
+	st.Expect(t, fsm.New().Rollback(), fsm.ErrNoHistory)
+}
+
+func TestMachineReset(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+	)
+
+	m := fsm.New(fsm.WithHistory(), fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, m.Transition(stateFinished), nil)
+	st.Expect(t, len(m.History()), 2)
+
+	st.Expect(t, m.Reset(), nil)
+	st.Expect(t, m.Current(), statePending)
+	st.Expect(t, m.History(), []fsm.HistoryEntry{})
+	st.Expect(t, m.Transition(stateStarted), nil)
+
+	m = fsm.New()
+	m.Rules, m.State = &rules, stateStarted
+	st.Expect(t, m.Reset(), fsm.ErrNoInitialState)
+	st.Expect(t, m.Current(), stateStarted)
+}
+
+func TestMachineReplay(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+	)
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	log := []fsm.HistoryEntry{
+		{From: statePending, To: stateStarted, Time: at},
+		{From: stateStarted, To: stateFinished, Time: at.Add(time.Hour)},
+	}
+
+	m := fsm.New(fsm.WithHistory())
+	m.Rules = &rules
+	st.Expect(t, m.Replay(log, true), nil)
+	st.Expect(t, m.Current(), stateFinished)
+	st.Expect(t, m.History(), log)
+
+	// an illegal step leaves the machine untouched
+	illegal := append(log[:1:1], fsm.HistoryEntry{From: stateStarted, To: statePending, Time: at})
+	n := fsm.New(fsm.WithInitialState(statePending), fsm.WithHistory())
+	n.Rules = &rules
+	err := n.Replay(illegal, true)
+	var perr *fsm.PathError
+	st.Assert(t, errors.As(err, &perr), true)
+	st.Expect(t, perr.Step, 1)
+	st.Expect(t, err.Error(), "Path step 1 failed: No rules found for started to pending")
+	st.Expect(t, n.Current(), statePending)
+	st.Expect(t, len(n.History()), 0)
+
+	// entries must follow each other
+	gap := []fsm.HistoryEntry{log[1]}
+	err = n.Replay(gap, true)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+	st.Expect(t, err.Error(), "Path step 0 failed: invalid transition: entry from started while at pending")
+
+	// without verifying, entries are applied as they are
+	st.Expect(t, n.Replay(illegal, false), nil)
+	st.Expect(t, n.Current(), statePending)
+	st.Expect(t, len(n.History()), 2)
+
+	// terminal states aren't left while verifying
+	terminal := rules.Clone()
+	terminal.MarkTerminal(statePending)
+	o := fsm.New(fsm.WithRules(&terminal))
+	err = o.Replay(log, true)
+	st.Expect(t, errors.Is(err, fsm.ErrTerminalState), true)
+	st.Expect(t, o.Current(), fsm.State{})
+}
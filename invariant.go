@@ -0,0 +1,33 @@
+package fsm
+
+// AddEntryInvariant adds guards which have to pass to enter the state,
+// whatever the transition, e.g. "a shipped order has an address". They are
+// the last word: invariants are only run once all the other guards of the
+// transition passed (its rule, origin, exit and global guards, in that
+// order), including when one of them returned Allow, and whatever their
+// tiers. Invariants are run one at a time in the order they were added,
+// and like other guards they don't make a transition without rule
+// permitted. A failing invariant is reported by a TransitionError whose
+// Guard is its index following the other guards.
+func (r *Ruleset) AddEntryInvariant(state IDer, guards ...Guard) {
+	if r.invariants == nil {
+		r.invariants = map[ID][]guardEntry{}
+	}
+	for _, guard := range guards {
+		r.invariants[state.ID()] = append(r.invariants[state.ID()], newGuard(guard))
+	}
+}
+
+// invariantSteps returns the invariants of the exit as steps, indexed from
+// offset
+func (r *Ruleset) invariantSteps(exit ID, offset int) []step {
+	invariants := r.invariants[exit]
+	if len(invariants) == 0 {
+		return nil
+	}
+	steps := make([]step, len(invariants))
+	for i, guard := range invariants {
+		steps[i] = step{offset + i, guard}
+	}
+	return steps
+}
@@ -0,0 +1,74 @@
+package fsm_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestRulesetEntryInvariant(t *testing.T) {
+	hasAddress := false
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	record := func(name string, err error) fsm.Guard {
+		return func(start *fsm.State, goal *fsm.State) error {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return err
+		}
+	}
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateFinished),
+		fsm.NewTransition(stateStarted, stateFinished),
+	)
+	rules.AddEntryInvariant(stateFinished, func(start *fsm.State, goal *fsm.State) error {
+		record("invariant", nil)(start, goal)
+		if !hasAddress {
+			return testError
+		}
+		return nil
+	})
+	rules.AddRuleTiered(fsm.NewTransition(statePending, stateFinished), 1, record("tiered", nil))
+	rules.AddExitGuard(stateFinished, record("exit", nil))
+	rules.AddGlobalGuard(record("global", nil))
+
+	// the invariant is the final gate, blocking entry from every origin
+	err := rules.Permitted(&statePending, &stateFinished)
+	st.Expect(t, errors.Is(err, testError), true)
+	st.Expect(t, order, []string{"exit", "global", "tiered", "invariant"})
+	var terr *fsm.TransitionError
+	st.Assert(t, errors.As(err, &terr), true)
+	st.Expect(t, terr.Guard, 4)
+	st.Expect(t, errors.Is(rules.Permitted(&stateStarted, &stateFinished), testError), true)
+	st.Expect(t, rules.PermittedMany(&stateStarted, stateFinished), map[fsm.ID]bool{stateFinished.ID(): false})
+	st.Expect(t, len(rules.PermittedDetailed(&stateStarted, &stateFinished)), 1)
+
+	// guards returning Allow don't bypass it, nor concurrent evaluation
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return fsm.Allow
+	})
+	m := fsm.New(fsm.WithInitialState(stateStarted), fsm.WithGuardConcurrency(4))
+	m.Rules = &rules
+	st.Expect(t, errors.Is(m.Transition(stateFinished), testError), true)
+
+	// guards run concurrently may still be recording
+	hasAddress = true
+	mu.Lock()
+	order = nil
+	mu.Unlock()
+	st.Expect(t, rules.Permitted(&statePending, &stateFinished), nil)
+	mu.Lock()
+	st.Expect(t, strings.Contains(strings.Join(order, " "), "invariant"), true)
+	mu.Unlock()
+	st.Expect(t, m.Transition(stateFinished), nil)
+
+	// invariants don't open transitions without rule
+	st.Expect(t, rules.Permitted(&stateFinished, &stateFinished).Error(), "No rules found for finished to finished")
+}
@@ -0,0 +1,120 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	errLoadFormat          = "%w: %s"
+	errEmptyStateFormat    = "%w: transition %d has an empty state"
+	errUnknownGuardsFormat = "%w: unknown guards %s"
+)
+
+var (
+	// ErrInvalidRuleset is returned when loading a malformed ruleset
+	ErrInvalidRuleset = errors.New("invalid ruleset")
+)
+
+// Definition describes a ruleset in a serializable form, as read by
+// LoadJSON or by loaders of other formats, see Definition.Ruleset
+type Definition struct {
+	Transitions []TransitionDefinition `json:"transitions" yaml:"transitions"`
+}
+
+// TransitionDefinition describes a transition of a Definition, with the
+// names of its guards
+type TransitionDefinition struct {
+	From   string   `json:"from" yaml:"from"`
+	To     string   `json:"to" yaml:"to"`
+	Guards []string `json:"guards,omitempty" yaml:"guards,omitempty"`
+}
+
+// Ruleset builds the ruleset described by d. States are loaded as String,
+// and "*" as origin stands for AnyState. Every transition gets the default
+// guard of AddTransition, then the guards it names, looked up in the
+// registry and added with AddNamedRule. Unknown guard names fail with an
+// error wrapping ErrInvalidRuleset listing all of them.
+func (d Definition) Ruleset(registry map[string]Guard) (Ruleset, error) {
+	return d.build(registry, true)
+}
+
+// GuardCatalog is a curated set of guards picked by name from
+// configuration, see GuardCatalog.Ruleset
+type GuardCatalog map[string]Guard
+
+// Ruleset builds the ruleset described by d with the guards of c, like
+// Definition.Ruleset but without adding the default guard of AddTransition:
+// a transition only gets the guards it names, and one naming no guard is a
+// rule without guards, handled by the EmptyGuardPolicy of the machine (see
+// WithEmptyGuardPolicy). Unknown guard names fail with an error wrapping
+// ErrInvalidRuleset listing all of them.
+func (c GuardCatalog) Ruleset(d Definition) (Ruleset, error) {
+	return d.build(c, false)
+}
+
+// build builds the ruleset described by d with the guards of the registry,
+// defaults telling whether the transitions get the default guard
+func (d Definition) build(registry map[string]Guard, defaults bool) (Ruleset, error) {
+	rules := Ruleset{}
+	missing := map[string]bool{}
+	for i, t := range d.Transitions {
+		if t.From == "" || t.To == "" {
+			return Ruleset{}, fmt.Errorf(errEmptyStateFormat, ErrInvalidRuleset, i)
+		}
+		transition := loadedTransition(t.From, t.To)
+		if defaults {
+			rules.AddTransition(transition)
+		} else if len(t.Guards) == 0 {
+			rules.AddRule(transition)
+		}
+		for _, name := range t.Guards {
+			guard, ok := registry[name]
+			if !ok {
+				missing[name] = true
+				continue
+			}
+			rules.AddNamedRule(transition, name, guard)
+		}
+	}
+
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return Ruleset{}, fmt.Errorf(errUnknownGuardsFormat, ErrInvalidRuleset, strings.Join(names, ", "))
+	}
+	return rules, nil
+}
+
+// LoadJSON builds a ruleset from a JSON document listing transitions:
+//
+//	{"transitions": [{"from": "pending", "to": "started"}]}
+//
+// States are loaded as String, and "*" as origin stands for AnyState.
+// Guards can't be serialized, so every transition only gets the default
+// guard of AddTransition; custom guards can be added afterwards, e.g.
+// AddRule(NewTransition(String("pending"), String("started")), guard).
+// To reference guards by name, decode a Definition and use its Ruleset.
+func LoadJSON(r io.Reader) (Ruleset, error) {
+	var doc Definition
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return Ruleset{}, fmt.Errorf(errLoadFormat, ErrInvalidRuleset, err)
+	}
+	return doc.Ruleset(nil)
+}
+
+// loadedTransition returns the transition between the named states
+func loadedTransition(from, to string) T {
+	var origin IDer = String(from)
+	if from == "*" {
+		origin = AnyState
+	}
+	return NewTransition(origin, String(to))
+}
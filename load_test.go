@@ -0,0 +1,103 @@
+package fsm_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestLoadJSON(t *testing.T) {
+	rules, err := fsm.LoadJSON(strings.NewReader(`{"transitions": [
+		{"from": "pending", "to": "started"},
+		{"from": "started", "to": "finished"},
+		{"from": "*", "to": "cancelled"}
+	]}`))
+	st.Assert(t, err, nil)
+
+	cancelled := fsm.NewState(fsm.String("cancelled"))
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	st.Expect(t, rules.Permitted(&stateStarted, &stateFinished), nil)
+	st.Expect(t, rules.Permitted(&stateFinished, &cancelled), nil)
+	st.Reject(t, rules.Permitted(&statePending, &stateFinished), nil)
+
+	// custom guards can be attached by transition
+	rules.AddRule(fsm.NewTransition(fsm.String("pending"), fsm.String("started")), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+	st.Expect(t, errors.Is(rules.Permitted(&statePending, &stateStarted), testError), true)
+}
+
+func TestLoadJSONErrors(t *testing.T) {
+	_, err := fsm.LoadJSON(strings.NewReader(`{"transitions": [`))
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidRuleset), true)
+
+	_, err = fsm.LoadJSON(strings.NewReader(`{"transitions": [{"from": "a", "to": "b"}, {"from": "b"}]}`))
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidRuleset), true)
+	st.Expect(t, err.Error(), "invalid ruleset: transition 1 has an empty state")
+}
+
+func TestDefinitionRuleset(t *testing.T) {
+	def := fsm.Definition{Transitions: []fsm.TransitionDefinition{
+		{From: "pending", To: "started", Guards: []string{"paid", "stocked"}},
+		{From: "started", To: "finished"},
+	}}
+	paid := true
+	registry := map[string]fsm.Guard{
+		"paid": func(start *fsm.State, goal *fsm.State) error {
+			if !paid {
+				return fsm.Deny("not paid")
+			}
+			return nil
+		},
+		"stocked": func(start *fsm.State, goal *fsm.State) error { return nil },
+	}
+
+	rules, err := def.Ruleset(registry)
+	st.Assert(t, err, nil)
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	st.Expect(t, rules.Permitted(&stateStarted, &stateFinished), nil)
+
+	paid = false
+	var terr *fsm.TransitionError
+	st.Expect(t, errors.As(rules.Permitted(&statePending, &stateStarted), &terr), true)
+	st.Expect(t, terr.Name, "paid")
+
+	// every unknown guard is listed
+	def.Transitions[1].Guards = []string{"shipped", "audited", "paid"}
+	_, err = def.Ruleset(map[string]fsm.Guard{"paid": registry["paid"]})
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidRuleset), true)
+	st.Expect(t, err.Error(), "invalid ruleset: unknown guards audited, shipped, stocked")
+}
+
+func TestGuardCatalogRuleset(t *testing.T) {
+	catalog := fsm.GuardCatalog{
+		"paid":    func(start *fsm.State, goal *fsm.State) error { return nil },
+		"stocked": func(start *fsm.State, goal *fsm.State) error { return fsm.Deny("out of stock") },
+	}
+	def := fsm.Definition{Transitions: []fsm.TransitionDefinition{
+		{From: "pending", To: "started", Guards: []string{"paid"}},
+		{From: "started", To: "finished", Guards: []string{"stocked"}},
+		{From: "*", To: "cancelled"},
+	}}
+
+	rules, err := catalog.Ruleset(def)
+	st.Assert(t, err, nil)
+	st.Expect(t, rules.GuardCount(fsm.NewTransition(statePending, stateStarted)), 1)
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted), nil)
+	st.Expect(t, errors.Is(rules.Permitted(&stateStarted, &stateFinished), fsm.ErrDenied), true)
+
+	// transitions without guards follow the empty guard policy
+	cancelled := fsm.NewState(fsm.String("cancelled"))
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithRules(&rules))
+	st.Reject(t, m.Transition(cancelled), nil)
+	m = fsm.New(fsm.WithInitialState(statePending), fsm.WithRules(&rules), fsm.WithEmptyGuardPolicy(fsm.PolicyOpen))
+	st.Expect(t, m.Transition(cancelled), nil)
+
+	def.Transitions[1].Guards = []string{"stocked", "shipped"}
+	_, err = catalog.Ruleset(def)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidRuleset), true)
+	st.Expect(t, err.Error(), "invalid ruleset: unknown guards shipped")
+}
@@ -18,8 +18,8 @@ type FlowState struct {
 func (f FlowState) ID() fsm.ID { return f.Name }
 
 // checkEvolve will be a guard for checking if a transition can go through
-func checkEvolve(start fsm.State, goal fsm.State) error {
-	if start.I().(FlowState).CanEvolve {
+func checkEvolve(start *fsm.State, goal *fsm.State) error {
+	if start.I.(FlowState).CanEvolve {
 		return nil
 	}
 	return errors.New("Can't evolve")
@@ -56,7 +56,7 @@ func main() {
 			return
 		}
 	}
-	fmt.Println(machine) // finished
+	fmt.Println(machine.State) // finished
 
 	// Test flow2
 	machine.State = flow2[0]
@@ -66,7 +66,7 @@ func main() {
 			break
 		}
 	}
-	fmt.Println(machine) // pending
+	fmt.Println(machine.State) // pending
 
 	// Test flow3
 	machine.State = flow3[0]
@@ -76,5 +76,5 @@ func main() {
 			break
 		}
 	}
-	fmt.Println(machine) // pending
+	fmt.Println(machine.State) // pending
 }
@@ -0,0 +1,62 @@
+package fsm
+
+// matchRule is a rule from an origin to the goals matched by a predicate,
+// see AddRuleFunc
+type matchRule struct {
+	match  func(goal State) bool
+	guards []guardEntry
+}
+
+// AddRuleFunc adds a rule from the origin to every goal for which match
+// returns true, e.g. any state prefixed "error_", guarded by the guards.
+// Without guards it is a rule without guards, handled by the
+// EmptyGuardPolicy like the others (denied by default, see
+// WithEmptyGuardPolicy). Rules matching by key win: predicate
+// rules are only consulted when the transition has no rule, including from
+// a parent of the origin or AnyState, the first matching predicate added
+// for the origin being used. The origin, exit and global guards still
+// apply. Predicate rules are only known by Permitted and the methods
+// running guards for a given goal, not by the ones listing transitions
+// such as Transitions or PermittedFrom.
+func (r *Ruleset) AddRuleFunc(origin IDer, match func(goal State) bool, guards ...Guard) {
+	if r.matches == nil {
+		r.matches = map[ID][]matchRule{}
+	}
+	rule := matchRule{match: match}
+	for _, guard := range guards {
+		rule.guards = append(rule.guards, newGuard(guard))
+	}
+	r.matches[origin.ID()] = append(r.matches[origin.ID()], rule)
+}
+
+// matchFor returns the guards of the first predicate rule from the origin
+// matching the goal, and whether there is one
+func (r *Ruleset) matchFor(origin ID, goal *State) ([]guardEntry, bool) {
+	for _, rule := range r.matches[origin] {
+		if rule.match(*goal) {
+			return rule.guards, true
+		}
+	}
+	return nil, false
+}
+
+// hasMatch reports whether a predicate rule from the origin matches the goal
+func (r *Ruleset) hasMatch(origin ID, goal State) bool {
+	_, ok := r.matchFor(origin, &goal)
+	return ok
+}
+
+// mergeMatches appends the predicate rules of src to the ones of dst,
+// returning dst which is created if needed
+func mergeMatches(dst, src map[ID][]matchRule) map[ID][]matchRule {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[ID][]matchRule, len(src))
+	}
+	for id, rules := range src {
+		dst[id] = append(dst[id][:len(dst[id]):len(dst[id])], rules...)
+	}
+	return dst
+}
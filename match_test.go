@@ -0,0 +1,62 @@
+package fsm_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestRulesetAddRuleFunc(t *testing.T) {
+	errorTimeout := fsm.NewState(fsm.String("error_timeout"))
+	errorDeclined := fsm.NewState(fsm.String("error_declined"))
+	errorFraud := fsm.NewState(fsm.String("error_fraud"))
+	isError := func(goal fsm.State) bool {
+		return strings.HasPrefix(goal.String(), "error_")
+	}
+
+	rules := fsm.Ruleset{}
+	var checked []fsm.ID
+	rules.AddRuleFunc(statePending, isError, func(start *fsm.State, goal *fsm.State) error {
+		checked = append(checked, goal.ID())
+		return nil
+	})
+	st.Expect(t, rules.Permitted(&statePending, &errorTimeout), nil)
+	st.Expect(t, rules.Permitted(&statePending, &errorDeclined), nil)
+	st.Expect(t, checked, []fsm.ID{errorTimeout.ID(), errorDeclined.ID()})
+	st.Expect(t, rules.Permitted(&statePending, &stateStarted).Error(), "No rules found for pending to started")
+	st.Expect(t, rules.Permitted(&stateStarted, &errorTimeout).Error(), "No rules found for started to error_timeout")
+
+	// exact rules win over predicates
+	rules.AddRule(fsm.NewTransition(statePending, errorFraud), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+	checked = nil
+	st.Expect(t, errors.Is(rules.Permitted(&statePending, &errorFraud), testError), true)
+	st.Expect(t, checked, []fsm.ID(nil))
+
+	// exit guards still apply, on clones too
+	rules.AddExitGuard(errorDeclined, func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+	clone := rules.Clone()
+	st.Expect(t, errors.Is(clone.Permitted(&statePending, &errorDeclined), testError), true)
+	st.Expect(t, clone.Permitted(&statePending, &errorTimeout), nil)
+
+	// predicate rules without guards follow the empty guard policy
+	rules = fsm.Ruleset{}
+	rules.AddRuleFunc(statePending, isError)
+	st.Expect(t, rules.Permitted(&statePending, &errorTimeout).Error(), "No rules found for pending to error_timeout")
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithRules(&rules))
+	st.Reject(t, m.Transition(errorTimeout), nil)
+	m = fsm.New(fsm.WithInitialState(statePending), fsm.WithRules(&rules), fsm.WithEmptyGuardPolicy(fsm.PolicyOpen))
+	st.Expect(t, m.Transition(errorTimeout), nil)
+
+	// strict machines know the goals matched from the current state
+	m = fsm.New(fsm.WithInitialState(statePending), fsm.WithRules(&rules), fsm.WithEmptyGuardPolicy(fsm.PolicyOpen), fsm.WithStrictStates())
+	st.Expect(t, errors.Is(m.Transition(stateStarted), fsm.ErrUnknownState), true)
+	st.Expect(t, m.Transition(errorTimeout), nil)
+	st.Expect(t, errors.Is(m.Transition(errorDeclined), fsm.ErrUnknownState), true)
+}
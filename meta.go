@@ -0,0 +1,50 @@
+package fsm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SetMeta attaches metadata to the transition, e.g. a display label or the
+// role required by an API, replacing the previous metadata. It doesn't
+// affect the evaluation of the rules but is part of the exports: Describe,
+// ToDOT and ToMermaid. A nil or empty meta removes the metadata.
+func (r *Ruleset) SetMeta(t Transition, meta map[string]string) {
+	if len(meta) == 0 {
+		delete(r.meta, key(t))
+		return
+	}
+	if r.meta == nil {
+		r.meta = map[T]map[string]string{}
+	}
+	r.meta[key(t)] = copyMeta(meta)
+}
+
+// Meta returns a copy of the metadata of the transition, nil if it has
+// none, see SetMeta
+func (r *Ruleset) Meta(t Transition) map[string]string {
+	return copyMeta(r.meta[key(t)])
+}
+
+// copyMeta returns a copy of meta, nil if it is empty
+func copyMeta(meta map[string]string) map[string]string {
+	if len(meta) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(meta))
+	for k, v := range meta {
+		c[k] = v
+	}
+	return c
+}
+
+// metaPairs returns the metadata of t as "key=value" strings sorted by key
+func (r *Ruleset) metaPairs(t T) []string {
+	meta := r.meta[t]
+	pairs := make([]string, 0, len(meta))
+	for k, v := range meta {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return pairs
+}
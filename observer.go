@@ -0,0 +1,40 @@
+package fsm
+
+import "time"
+
+// Observer is notified of the transitions attempted by a Machine, e.g. to
+// emit metrics. Methods are called while the machine is locked and should
+// return quickly.
+type Observer interface {
+	// TransitionAttempted is called before the guards of t are run
+	TransitionAttempted(t Transition)
+	// TransitionAllowed is called once t is permitted, before the state changes
+	TransitionAllowed(t Transition)
+	// TransitionDenied is called when t isn't permitted, with the error
+	// returned by Transition
+	TransitionDenied(t Transition, reason error)
+	// GuardDuration reports how long evaluating the guards of t took
+	GuardDuration(t Transition, d time.Duration)
+}
+
+// WithObserver attaches an observer to the machine, by default none is
+// attached and observing costs nothing
+func WithObserver(o Observer) func(*Machine) {
+	return func(m *Machine) {
+		m.observer = o
+	}
+}
+
+// Logger is told about the transitions denied by a Machine, with the
+// reason they were, e.g. no rule or a failing guard, see WithLogger
+type Logger interface {
+	Log(t Transition, reason string)
+}
+
+// WithLogger makes the machine log the transitions it denies, by default
+// nothing is logged
+func WithLogger(l Logger) func(*Machine) {
+	return func(m *Machine) {
+		m.logger = l
+	}
+}
@@ -0,0 +1,84 @@
+package fsm_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+type recordingObserver struct {
+	events    []string
+	durations []time.Duration
+}
+
+func (o *recordingObserver) TransitionAttempted(t fsm.Transition) {
+	o.events = append(o.events, fmt.Sprintf("attempted %v->%v", t.Origin(), t.Exit()))
+}
+
+func (o *recordingObserver) TransitionAllowed(t fsm.Transition) {
+	o.events = append(o.events, fmt.Sprintf("allowed %v->%v", t.Origin(), t.Exit()))
+}
+
+func (o *recordingObserver) TransitionDenied(t fsm.Transition, reason error) {
+	o.events = append(o.events, fmt.Sprintf("denied %v->%v: %v", t.Origin(), t.Exit(), reason))
+}
+
+func (o *recordingObserver) GuardDuration(t fsm.Transition, d time.Duration) {
+	o.durations = append(o.durations, d)
+}
+
+func TestMachineObserver(t *testing.T) {
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+
+	o := &recordingObserver{}
+	m := fsm.New(fsm.WithObserver(o))
+	m.State = statePending
+	m.Rules = &rules
+
+	st.Reject(t, m.Transition(stateFinished), nil)
+	st.Expect(t, m.Transition(stateStarted), nil)
+
+	st.Expect(t, o.events, []string{
+		"attempted pending->finished",
+		"denied pending->finished: No rules found for pending to finished",
+		"attempted pending->started",
+		"allowed pending->started",
+	})
+	st.Assert(t, len(o.durations), 2)
+	st.Expect(t, o.durations[1] >= 5*time.Millisecond, true)
+}
+
+type recordingLogger struct{ lines []string }
+
+func (l *recordingLogger) Log(t fsm.Transition, reason string) {
+	l.lines = append(l.lines, fmt.Sprintf("%v->%v: %s", t.Origin(), t.Exit(), reason))
+}
+
+func TestMachineLogger(t *testing.T) {
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		return fsm.Deny("not paid")
+	})
+
+	l := &recordingLogger{}
+	m := fsm.New(fsm.WithLogger(l), fsm.WithInitialState(statePending))
+	m.Rules = &rules
+
+	st.Reject(t, m.Transition(stateStarted), nil)
+	st.Reject(t, m.Transition(stateFinished), nil)
+	st.Expect(t, l.lines, []string{
+		"pending->started: Guard failed from pending to started: not paid",
+		"pending->finished: No rules found for pending to finished",
+	})
+
+	rules.RemoveGuard(fsm.NewTransition(statePending, stateStarted), 1)
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, len(l.lines), 2)
+}
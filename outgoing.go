@@ -0,0 +1,55 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	errNoOutgoingFormat = "%w from %s: %w"
+)
+
+var (
+	// ErrNoOutgoing is returned when there is no rule at all leaving the
+	// state, which is a sink, see WithSinkDetection and Machine.Step
+	ErrNoOutgoing = errors.New("no outgoing transitions")
+)
+
+// WithSinkDetection makes the transitions from a state no rule leaves,
+// including from its parents and AnyState, fail with an error wrapping
+// ErrNoOutgoing, e.g. to tell the user an item is in a final state. The
+// error still wraps the *TransitionError otherwise returned. States with
+// rules leaving them whose guards deny fail as usual.
+func WithSinkDetection() func(*Machine) {
+	return func(m *Machine) {
+		m.sinks = true
+	}
+}
+
+// hasOutgoing reports whether any rule applies to transitions leaving id,
+// see Permitted
+func (r *Ruleset) hasOutgoing(id ID) bool {
+	if len(r.matches[id]) > 0 {
+		return true
+	}
+	origins := map[ID]bool{id: true, AnyState.ID(): true}
+	for _, ancestor := range r.ancestors(id) {
+		origins[ancestor] = true
+	}
+	for t := range r.rules {
+		if origins[t.O] {
+			return true
+		}
+	}
+	return false
+}
+
+// sinkError returns err wrapped with ErrNoOutgoing if it is for a missing
+// rule from a sink state
+func (r *Ruleset) sinkError(start ID, err error) error {
+	var terr *TransitionError
+	if errors.As(err, &terr) && terr.Guard == -1 && !r.hasOutgoing(start) {
+		return fmt.Errorf(errNoOutgoingFormat, ErrNoOutgoing, start, err)
+	}
+	return err
+}
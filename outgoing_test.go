@@ -0,0 +1,42 @@
+package fsm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestMachineSinkDetection(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+	)
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+
+	m := fsm.New(fsm.WithInitialState(stateFinished), fsm.WithSinkDetection())
+	m.Rules = &rules
+	err := m.Transition(statePending)
+	st.Expect(t, errors.Is(err, fsm.ErrNoOutgoing), true)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+	st.Expect(t, err.Error(), "no outgoing transitions from finished: No rules found for finished to pending")
+
+	// guards denying or a missing rule from a state having others
+	n := m.For(statePending)
+	err = n.Transition(stateStarted)
+	st.Expect(t, errors.Is(err, testError), true)
+	st.Expect(t, errors.Is(err, fsm.ErrNoOutgoing), false)
+	st.Expect(t, errors.Is(n.Transition(stateFinished), fsm.ErrNoOutgoing), false)
+
+	// a rule from AnyState leaves every state
+	rules.AddTransition(fsm.NewTransition(fsm.AnyState, stateStarted))
+	st.Expect(t, errors.Is(m.Transition(statePending), fsm.ErrNoOutgoing), false)
+
+	// without the option the error is unchanged
+	o := fsm.New(fsm.WithInitialState(stateFinished))
+	o.Rules = &rules
+	st.Expect(t, o.Transition(statePending).Error(), "No rules found for finished to pending")
+}
@@ -0,0 +1,45 @@
+package fsm
+
+import "context"
+
+// payloadKey is the context key of the payload given to TransitionWith
+type payloadKey struct{}
+
+// Payload returns the payload given to TransitionWith for the transition
+// the guard receiving ctx is run for, nil if there is none
+func Payload(ctx context.Context) interface{} {
+	return ctx.Value(payloadKey{})
+}
+
+// PayloadCallback is a Callback also receiving the payload given to
+// TransitionWith, nil for other transitions
+type PayloadCallback func(start State, goal State, payload interface{})
+
+// OnEnterWith is like OnEnter for callbacks receiving the payload
+func (m *Machine) OnEnterWith(s IDer, callbacks ...PayloadCallback) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.onEnter == nil {
+		m.onEnter = map[ID][]PayloadCallback{}
+	}
+	m.onEnter[s.ID()] = append(m.onEnter[s.ID()], callbacks...)
+}
+
+// OnExitWith is like OnExit for callbacks receiving the payload
+func (m *Machine) OnExitWith(s IDer, callbacks ...PayloadCallback) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.onExit == nil {
+		m.onExit = map[ID][]PayloadCallback{}
+	}
+	m.onExit[s.ID()] = append(m.onExit[s.ID()], callbacks...)
+}
+
+// TransitionWith is like Transition but carries a payload (e.g. the acting
+// user, a reason) along the transition: context aware guards get it with
+// Payload and callbacks registered with OnEnterWith or OnExitWith receive
+// it. The payload is shared as is, including by guards run concurrently
+// (see WithGuardConcurrency), so they must not modify it.
+func (m *Machine) TransitionWith(goal State, payload interface{}) error {
+	return m.TransitionCtx(context.WithValue(context.Background(), payloadKey{}, payload), goal)
+}
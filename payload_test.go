@@ -0,0 +1,43 @@
+package fsm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestMachineTransitionWith(t *testing.T) {
+	type approval struct{ user string }
+
+	rules := fsm.Ruleset{}
+	rules.AddRuleCtx(fsm.NewTransition(statePending, stateStarted), func(ctx context.Context, start *fsm.State, goal *fsm.State) error {
+		if p, ok := fsm.Payload(ctx).(approval); !ok || p.user != "admin" {
+			return fsm.Deny("admins only")
+		}
+		return nil
+	})
+
+	for _, opts := range [][]func(*fsm.Machine){nil, {fsm.WithGuardConcurrency(2)}} {
+		m := fsm.New(append(opts, fsm.WithInitialState(statePending))...)
+		m.Rules = &rules
+
+		var entered, exited interface{}
+		plain := 0
+		m.OnEnterWith(stateStarted, func(start fsm.State, goal fsm.State, payload interface{}) { entered = payload })
+		m.OnExitWith(statePending, func(start fsm.State, goal fsm.State, payload interface{}) { exited = payload })
+		m.OnEnter(stateStarted, func(start fsm.State, goal fsm.State) { plain++ })
+
+		st.Expect(t, errors.Is(m.Transition(stateStarted), fsm.ErrDenied), true)
+		st.Expect(t, errors.Is(m.TransitionWith(stateStarted, approval{"guest"}), fsm.ErrDenied), true)
+		st.Expect(t, m.Current(), statePending)
+
+		st.Expect(t, m.TransitionWith(stateStarted, approval{"admin"}), nil)
+		st.Expect(t, m.Current(), stateStarted)
+		st.Expect(t, entered, approval{"admin"})
+		st.Expect(t, exited, approval{"admin"})
+		st.Expect(t, plain, 1)
+	}
+}
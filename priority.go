@@ -0,0 +1,91 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+const (
+	errNoAdvanceFormat = "%w: nothing permitted from %s"
+	errNoGoalFormat    = "%w: none of %v permitted from %s"
+)
+
+// AddTransitionP is like AddTransition but also gives the transition a
+// priority, used by Machine.Advance to pick between the transitions
+// leaving a state, and adds the guards to its rule. Transitions added
+// without priority have a priority of 0.
+func (r *Ruleset) AddTransitionP(t Transition, priority int, guards ...Guard) {
+	r.AddTransition(t)
+	r.AddRule(t, guards...)
+	r.setPriority(key(t), priority)
+}
+
+// setPriority sets the priority of t
+func (r *Ruleset) setPriority(t T, priority int) {
+	if r.priorities == nil {
+		r.priorities = map[T]int{}
+	}
+	r.priorities[t] = priority
+}
+
+// candidates returns the rules applying to transitions leaving start (see
+// Permitted) by decreasing priority, ties being ordered by exit
+func (r *Ruleset) candidates(start ID) []T {
+	var ts []T
+	seen := map[T]bool{}
+	for _, t := range r.sortedTransitions() {
+		if rule, ok := r.ruleFor(start, t.E); ok && !seen[rule] {
+			seen[rule] = true
+			ts = append(ts, rule)
+		}
+	}
+	sort.Slice(ts, func(i, j int) bool {
+		if pi, pj := r.priorities[ts[i]], r.priorities[ts[j]]; pi != pj {
+			return pi > pj
+		}
+		return fmt.Sprint(ts[i].E) < fmt.Sprint(ts[j].E)
+	})
+	return ts
+}
+
+// Advance attempts the transitions leaving the current state by priority
+// (see AddTransitionP) and performs the first one permitted, returning the
+// state entered. As rules only know about IDs, that state only carries its
+// ID, see IDState. If none is permitted the state is left unchanged and an
+// error wrapping ErrInvalidTransition is returned.
+func (m *Machine) Advance() (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.validate(); err != nil {
+		return m.State, err
+	}
+	for _, t := range m.Rules.candidates(m.State.ID()) {
+		if m.transition(context.Background(), IDState(t.E)) == nil {
+			return m.State, nil
+		}
+	}
+	return m.State, fmt.Errorf(errNoAdvanceFormat, ErrInvalidTransition, m.State.ID())
+}
+
+// TransitionAny is like Advance but attempts the given goals in order,
+// performing the first one permitted and returning it. If none is
+// permitted the state is left unchanged and an error wrapping
+// ErrInvalidTransition is returned.
+func (m *Machine) TransitionAny(goals ...State) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.validate(); err != nil {
+		return m.State, err
+	}
+	ids := make([]ID, len(goals))
+	for i, goal := range goals {
+		if m.transition(context.Background(), goal) == nil {
+			return goal, nil
+		}
+		ids[i] = stateID(goal)
+	}
+	return m.State, fmt.Errorf(errNoGoalFormat, ErrInvalidTransition, ids, m.State.ID())
+}
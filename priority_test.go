@@ -0,0 +1,102 @@
+package fsm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestMachineAdvance(t *testing.T) {
+	stateCancelled := fsm.NewState(fsm.String("cancelled"))
+	allowed := true
+
+	rules := fsm.Ruleset{}
+	rules.AddTransitionP(fsm.NewTransition(statePending, stateCancelled), 0)
+	rules.AddTransitionP(fsm.NewTransition(statePending, stateStarted), 10, func(start *fsm.State, goal *fsm.State) error {
+		if !allowed {
+			return testError
+		}
+		return nil
+	})
+	rules.AddTransition(fsm.NewTransition(statePending, stateFinished))
+
+	m := fsm.New(fsm.WithInitialState(statePending))
+	m.Rules = &rules
+
+	// the highest priority wins
+	s, err := m.Advance()
+	st.Expect(t, err, nil)
+	st.Expect(t, s.ID(), stateStarted.ID())
+	st.Expect(t, m.Current().ID(), stateStarted.ID())
+
+	// the next permitted one is taken, ties are ordered by exit
+	m.State = statePending
+	allowed = false
+	s, err = m.Advance()
+	st.Expect(t, err, nil)
+	st.Expect(t, s.ID(), stateCancelled.ID())
+
+	// nothing is permitted
+	s, err = m.Advance()
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+	st.Expect(t, s.ID(), stateCancelled.ID())
+
+	// priorities are cloned and removed with their rule
+	c := rules.Clone()
+	c.RemoveRule(fsm.NewTransition(statePending, stateStarted))
+	c.AddTransition(fsm.NewTransition(statePending, stateStarted))
+	allowed = true
+	m.Rules, m.State = &c, statePending
+	s, err = m.Advance()
+	st.Expect(t, err, nil)
+	st.Expect(t, s.ID(), stateCancelled.ID())
+
+	m.Rules, m.State = &rules, statePending
+	s, err = m.Advance()
+	st.Expect(t, err, nil)
+	st.Expect(t, s.ID(), stateStarted.ID())
+}
+
+func TestMachineTransitionAny(t *testing.T) {
+	stateCancelled := fsm.NewState(fsm.String("cancelled"))
+	allowed := true
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateCancelled),
+		fsm.NewTransition(statePending, stateStarted),
+	)
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		if !allowed {
+			return testError
+		}
+		return nil
+	})
+
+	m := fsm.New(fsm.WithInitialState(statePending))
+	m.Rules = &rules
+
+	// the order of the caller wins
+	s, err := m.TransitionAny(stateStarted, stateCancelled)
+	st.Expect(t, err, nil)
+	st.Expect(t, s, stateStarted)
+	st.Expect(t, m.Reset(), nil)
+	s, err = m.TransitionAny(stateCancelled, stateStarted)
+	st.Expect(t, err, nil)
+	st.Expect(t, s, stateCancelled)
+
+	// denied goals are skipped
+	st.Expect(t, m.Reset(), nil)
+	allowed = false
+	s, err = m.TransitionAny(stateFinished, stateStarted, stateCancelled)
+	st.Expect(t, err, nil)
+	st.Expect(t, s, stateCancelled)
+
+	// nothing is permitted
+	st.Expect(t, m.Reset(), nil)
+	s, err = m.TransitionAny(stateFinished, stateStarted)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidTransition), true)
+	st.Expect(t, err.Error(), "invalid transition: none of [finished started] permitted from pending")
+	st.Expect(t, s, statePending)
+	st.Expect(t, m.Current(), statePending)
+}
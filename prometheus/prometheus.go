@@ -0,0 +1,65 @@
+// Package prometheus implements an fsm.Observer exporting Prometheus
+// metrics, kept apart so the fsm package has no dependency:
+//
+//	o, err := prometheus.New(prom.DefaultRegisterer)
+//	machine := fsm.New(fsm.WithObserver(o))
+package prometheus
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/processout/fsm"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Observer counts the transitions attempted by machines in
+// fsm_transitions_total{from,to,result}, result being "allowed" or
+// "denied", and records the time taken by their guards in the
+// fsm_guard_duration_seconds{from,to} histogram
+type Observer struct {
+	transitions *prom.CounterVec
+	durations   *prom.HistogramVec
+}
+
+var _ fsm.Observer = (*Observer)(nil)
+
+// New creates an Observer registering its metrics against reg
+func New(reg prom.Registerer) (*Observer, error) {
+	o := &Observer{
+		transitions: prom.NewCounterVec(prom.CounterOpts{
+			Name: "fsm_transitions_total",
+			Help: "Transitions attempted by state machines, by result.",
+		}, []string{"from", "to", "result"}),
+		durations: prom.NewHistogramVec(prom.HistogramOpts{
+			Name:    "fsm_guard_duration_seconds",
+			Help:    "Time taken by the guards of the transitions.",
+			Buckets: prom.DefBuckets,
+		}, []string{"from", "to"}),
+	}
+	for _, c := range []prom.Collector{o.transitions, o.durations} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// TransitionAttempted implements fsm.Observer, attempts are counted once
+// their result is known
+func (o *Observer) TransitionAttempted(t fsm.Transition) {}
+
+// TransitionAllowed implements fsm.Observer
+func (o *Observer) TransitionAllowed(t fsm.Transition) {
+	o.transitions.WithLabelValues(fmt.Sprint(t.Origin()), fmt.Sprint(t.Exit()), "allowed").Inc()
+}
+
+// TransitionDenied implements fsm.Observer
+func (o *Observer) TransitionDenied(t fsm.Transition, reason error) {
+	o.transitions.WithLabelValues(fmt.Sprint(t.Origin()), fmt.Sprint(t.Exit()), "denied").Inc()
+}
+
+// GuardDuration implements fsm.Observer
+func (o *Observer) GuardDuration(t fsm.Transition, d time.Duration) {
+	o.durations.WithLabelValues(fmt.Sprint(t.Origin()), fmt.Sprint(t.Exit())).Observe(d.Seconds())
+}
@@ -0,0 +1,42 @@
+package prometheus_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+	"github.com/processout/fsm/prometheus"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserver(t *testing.T) {
+	reg := prom.NewRegistry()
+	o, err := prometheus.New(reg)
+	st.Assert(t, err, nil)
+
+	pending, started := fsm.NewState(fsm.String("pending")), fsm.NewState(fsm.String("started"))
+	rules := fsm.CreateRuleset(fsm.NewTransition(pending, started))
+	m := fsm.New(fsm.WithObserver(o), fsm.WithInitialState(pending))
+	m.Rules = &rules
+
+	st.Expect(t, m.Transition(started), nil)
+	st.Reject(t, m.Transition(pending), nil)
+	st.Reject(t, m.Transition(pending), nil)
+
+	st.Expect(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP fsm_transitions_total Transitions attempted by state machines, by result.
+# TYPE fsm_transitions_total counter
+fsm_transitions_total{from="pending",result="allowed",to="started"} 1
+fsm_transitions_total{from="started",result="denied",to="pending"} 2
+`), "fsm_transitions_total"), nil)
+
+	n, err := testutil.GatherAndCount(reg, "fsm_guard_duration_seconds")
+	st.Expect(t, err, nil)
+	st.Expect(t, n, 2)
+
+	// registering twice fails
+	_, err = prometheus.New(reg)
+	st.Reject(t, err, nil)
+}
@@ -0,0 +1,103 @@
+package fsm
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrMachineClosed is delivered for the transitions enqueued after
+	// Machine.Close
+	ErrMachineClosed = errors.New("machine closed")
+)
+
+// queue holds the transitions enqueued on a machine until its worker
+// applies them
+type queue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	requests []request
+	closed   bool
+	drained  chan struct{}
+}
+
+// request is a transition waiting in a queue
+type request struct {
+	goal   State
+	result chan error
+}
+
+// Enqueue queues a transition to the goal and returns a channel delivering
+// its result, that of Transition, once applied. Enqueued transitions are
+// applied one at a time by a background goroutine, in the order they were
+// enqueued, which is started by the first call. The channel is buffered
+// and never needs to be read.
+func (m *Machine) Enqueue(goal State) <-chan error {
+	q := m.workQueue(true)
+	result := make(chan error, 1)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		result <- ErrMachineClosed
+		return result
+	}
+	q.requests = append(q.requests, request{goal, result})
+	q.cond.Signal()
+	return result
+}
+
+// Close stops the goroutine applying the enqueued transitions once it
+// applied the ones already queued, and waits for it. Transitions enqueued
+// afterwards fail with ErrMachineClosed, Transition and the other methods
+// keep working.
+func (m *Machine) Close() {
+	q := m.workQueue(false)
+
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Signal()
+	q.mu.Unlock()
+
+	<-q.drained
+}
+
+// workQueue returns the queue of m, creating it if needed, along with its
+// worker when start is true
+func (m *Machine) workQueue(start bool) *queue {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.queue == nil {
+		m.queue = &queue{drained: make(chan struct{})}
+		m.queue.cond = sync.NewCond(&m.queue.mu)
+		if start {
+			go m.work(m.queue)
+		} else {
+			close(m.queue.drained)
+		}
+	}
+	return m.queue
+}
+
+// work applies the requests of q until it is closed and empty
+func (m *Machine) work(q *queue) {
+	defer close(q.drained)
+
+	for {
+		q.mu.Lock()
+		for len(q.requests) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.requests) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		r := q.requests[0]
+		q.requests = q.requests[1:]
+		q.mu.Unlock()
+
+		r.result <- m.Transition(r.goal)
+	}
+}
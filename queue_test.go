@@ -0,0 +1,81 @@
+package fsm_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestMachineEnqueue(t *testing.T) {
+	rules := fsm.Ruleset{}
+	var states []fsm.State
+	for i := 0; i < 50; i++ {
+		s := fsm.NewState(fsm.String(fmt.Sprint("step", i)))
+		states = append(states, s)
+		rules.AddTransition(fsm.NewTransition(fsm.AnyState, s))
+	}
+	m := fsm.New(fsm.WithInitialState(statePending))
+	m.Rules = &rules
+
+	var entered []fsm.ID
+	for _, s := range states {
+		m.OnEnter(s, func(start fsm.State, goal fsm.State) {
+			entered = append(entered, goal.ID())
+		})
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		enqueued []fsm.ID
+		results  = make(chan (<-chan error), len(states))
+	)
+	for _, s := range states {
+		wg.Add(1)
+		go func(s fsm.State) {
+			defer wg.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			enqueued = append(enqueued, s.ID())
+			results <- m.Enqueue(s)
+		}(s)
+	}
+	wg.Wait()
+	close(results)
+	for result := range results {
+		st.Expect(t, <-result, nil)
+	}
+
+	m.Close()
+	st.Expect(t, entered, enqueued)
+	st.Expect(t, m.Current().ID(), enqueued[len(enqueued)-1])
+	st.Expect(t, <-m.Enqueue(stateStarted), fsm.ErrMachineClosed)
+}
+
+func TestMachineCloseDrains(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+	)
+	m := fsm.New(fsm.WithInitialState(statePending))
+	m.Rules = &rules
+
+	started := m.Enqueue(stateStarted)
+	denied := m.Enqueue(statePending)
+	finished := m.Enqueue(stateFinished)
+	m.Close()
+
+	st.Expect(t, <-started, nil)
+	st.Expect(t, (<-denied).Error(), "No rules found for started to pending")
+	st.Expect(t, <-finished, nil)
+	st.Expect(t, m.Current(), stateFinished)
+	m.Close()
+
+	// closing a machine which never enqueued
+	n := fsm.New(fsm.WithInitialState(statePending))
+	n.Close()
+	st.Expect(t, <-n.Enqueue(stateStarted), fsm.ErrMachineClosed)
+}
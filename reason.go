@@ -0,0 +1,84 @@
+package fsm
+
+import "errors"
+
+// Reason tells why a transition is permitted or not, see PermittedReason
+type Reason int
+
+const (
+	// ReasonOK is given when the transition is permitted
+	ReasonOK Reason = iota
+	// ReasonNoRule is given when there is no rule for the transition
+	ReasonNoRule
+	// ReasonGuardFailed is given when a guard of the transition failed,
+	// timed out or panicked
+	ReasonGuardFailed
+	// ReasonDisabled is given when the transition is disabled, see
+	// Ruleset.Disable
+	ReasonDisabled
+)
+
+// String returns the name of the reason, e.g. "no rule"
+func (r Reason) String() string {
+	switch r {
+	case ReasonOK:
+		return "ok"
+	case ReasonNoRule:
+		return "no rule"
+	case ReasonGuardFailed:
+		return "guard failed"
+	case ReasonDisabled:
+		return "disabled"
+	}
+	return "unknown"
+}
+
+// PermittedReason is like Permitted but tells why the transition isn't
+// permitted, e.g. to tell a missing transition (not found) from a denied
+// one (forbidden) without looking the rule up again.
+func (r *Ruleset) PermittedReason(start *State, goal *State) (bool, Reason) {
+	err := r.Permitted(start, goal)
+	return err == nil, reasonOf(err)
+}
+
+// reasonOf returns the reason of the error returned by Permitted
+func reasonOf(err error) Reason {
+	if err == nil {
+		return ReasonOK
+	}
+	if errors.Is(err, ErrDisabled) {
+		return ReasonDisabled
+	}
+	var terr *TransitionError
+	if errors.As(err, &terr) && terr.Guard == -1 {
+		return ReasonNoRule
+	}
+	return ReasonGuardFailed
+}
+
+// RejectCallback is run by the Machine when the rules deny a transition,
+// start being the current state and goal the state which was attempted
+type RejectCallback func(start State, goal State, reason Reason)
+
+// OnReject registers callbacks run, in order, whenever the rules deny a
+// transition of the machine, e.g. to record the attempt. It isn't run for
+// other failures, such as a machine without rules, a terminal state, an
+// interceptor (see WithPreTransition), a guard timeout or an EnterValidator.
+func (m *Machine) OnReject(callbacks ...RejectCallback) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onReject = append(m.onReject, callbacks...)
+}
+
+// reject runs the reject callbacks if err is a denial of the rules
+func (m *Machine) reject(goal State, err error) {
+	var terr *TransitionError
+	if len(m.onReject) == 0 || !errors.As(err, &terr) {
+		return
+	}
+	reason := reasonOf(err)
+	for _, c := range m.onReject {
+		c(m.State, goal, reason)
+	}
+}
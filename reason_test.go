@@ -0,0 +1,28 @@
+package fsm_test
+
+import (
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestRulesetPermittedReason(t *testing.T) {
+	rules := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	rules.AddRule(fsm.NewTransition(stateStarted, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+
+	ok, reason := rules.PermittedReason(&statePending, &stateStarted)
+	st.Expect(t, ok, true)
+	st.Expect(t, reason, fsm.ReasonOK)
+
+	ok, reason = rules.PermittedReason(&statePending, &stateFinished)
+	st.Expect(t, ok, false)
+	st.Expect(t, reason, fsm.ReasonNoRule)
+
+	ok, reason = rules.PermittedReason(&stateStarted, &stateFinished)
+	st.Expect(t, ok, false)
+	st.Expect(t, reason, fsm.ReasonGuardFailed)
+	st.Expect(t, reason.String(), "guard failed")
+}
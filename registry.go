@@ -0,0 +1,39 @@
+package fsm
+
+import "fmt"
+
+// RegisterStates declares states as valid. Once states are registered, the
+// rules of transitions from or to a state which isn't registered are
+// rejected: they aren't added and the error, wrapping ErrUnknownState, is
+// returned by AddTransitionStrict or recorded for Err. Without registered
+// states any state can be used. AnyState doesn't need to be registered.
+func (r *Ruleset) RegisterStates(states ...IDer) {
+	if r.registry == nil {
+		r.registry = map[ID]bool{}
+	}
+	for _, s := range states {
+		r.registry[s.ID()] = true
+	}
+}
+
+// Err returns the error of the first rule rejected because of a state
+// which isn't registered, see RegisterStates, or of a terminal origin, see
+// MarkTerminal. Machines using the ruleset
+// fail their transitions with it, so typos don't go unnoticed.
+func (r *Ruleset) Err() error {
+	return r.err
+}
+
+// checkRegistered returns an error wrapping ErrUnknownState if the origin
+// or exit of t isn't registered while states are
+func (r *Ruleset) checkRegistered(t T) error {
+	if len(r.registry) == 0 {
+		return nil
+	}
+	for _, id := range []ID{t.O, t.E} {
+		if id != AnyState.ID() && !r.registry[id] {
+			return fmt.Errorf(errUnknownStateFormat, ErrUnknownState, id)
+		}
+	}
+	return nil
+}
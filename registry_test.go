@@ -0,0 +1,47 @@
+package fsm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestRulesetRegisterStates(t *testing.T) {
+	typo := fsm.NewState(fsm.String("strated"))
+
+	// without registry any state is accepted
+	open := fsm.Ruleset{}
+	st.Expect(t, open.AddTransitionStrict(fsm.NewTransition(statePending, typo)), nil)
+	st.Expect(t, open.Err(), nil)
+
+	rules := fsm.Ruleset{}
+	rules.RegisterStates(statePending, stateStarted, stateFinished)
+	st.Expect(t, rules.AddTransitionStrict(fsm.NewTransition(statePending, stateStarted)), nil)
+	rules.AddTransition(fsm.NewTransition(fsm.AnyState, stateFinished))
+	st.Expect(t, rules.Err(), nil)
+
+	err := rules.AddTransitionStrict(fsm.NewTransition(statePending, typo))
+	st.Expect(t, errors.Is(err, fsm.ErrUnknownState), true)
+	st.Expect(t, err.Error(), "unknown state strated")
+	st.Expect(t, rules.Err(), nil)
+
+	rules.AddRule(fsm.NewTransition(typo, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return nil
+	})
+	st.Expect(t, rules.HasRule(fsm.NewTransition(typo, stateFinished)), false)
+	st.Expect(t, errors.Is(rules.Err(), fsm.ErrUnknownState), true)
+
+	// machines refuse to use rules with rejected transitions
+	m := fsm.New(fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	st.Expect(t, errors.Is(m.Transition(stateStarted), fsm.ErrUnknownState), true)
+
+	// clones keep the registry
+	clone := open.Clone()
+	clone.RegisterStates(statePending)
+	clone = clone.Clone()
+	clone.AddTransition(fsm.NewTransition(statePending, stateFinished))
+	st.Expect(t, errors.Is(clone.Err(), fsm.ErrUnknownState), true)
+}
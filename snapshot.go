@@ -0,0 +1,89 @@
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// snapshot is the JSON encoding of the runtime position of a machine
+type snapshot struct {
+	State   State           `json:"state"`
+	History []snapshotEntry `json:"history,omitempty"`
+}
+
+// snapshotEntry is the JSON encoding of a HistoryEntry
+type snapshotEntry struct {
+	From State     `json:"from"`
+	To   State     `json:"to"`
+	Time time.Time `json:"time"`
+}
+
+// Snapshot encodes the current state and the history of the machine as
+// JSON, e.g. to recover it after a crash with Restore. Like for
+// State.MarshalJSON only the IDs of the states are kept; the rules,
+// callbacks and options are code and are not part of the snapshot.
+func (m *Machine) Snapshot() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := snapshot{State: m.State}
+	if m.history != nil {
+		for _, e := range m.history.entries {
+			s.History = append(s.History, snapshotEntry(e))
+		}
+	}
+	return json.Marshal(s)
+}
+
+// Restore moves the machine to the state of a snapshot made by Snapshot,
+// replacing its history if enabled, the oldest entries past its limit
+// being dropped. States are restored with the IDs of the rules of the
+// machine having the same string form, see Ruleset.StateNamed, falling
+// back on String IDs (see State.UnmarshalJSON). Like Reset it bypasses the guards and runs no
+// callbacks. With WithStrictStates a snapshot whose state is unknown to
+// the rules is rejected with an error wrapping ErrUnknownState, leaving
+// the machine untouched.
+func (m *Machine) Restore(data []byte) error {
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s.State = m.resolve(s.State)
+	for i, e := range s.History {
+		s.History[i].From, s.History[i].To = m.resolve(e.From), m.resolve(e.To)
+	}
+	if m.strict && s.State.I != nil && (m.Rules == nil || !m.Rules.hasState(s.State.ID())) {
+		return fmt.Errorf(errUnknownStateFormat, ErrUnknownState, s.State.ID())
+	}
+	m.State = s.State
+	m.eval.cache.clear()
+	if m.history != nil {
+		entries := s.History
+		if m.history.limit > 0 && len(entries) > m.history.limit {
+			entries = entries[len(entries)-m.history.limit:]
+		}
+		m.history.entries = make([]HistoryEntry, len(entries))
+		for i, e := range entries {
+			m.history.entries[i] = HistoryEntry(e)
+		}
+	}
+	return nil
+}
+
+// resolve returns the state of the rules with the ID of the decoded state
+// s, s itself if its String ID is the one of the rules or the rules have
+// no such state
+func (m *Machine) resolve(s State) State {
+	if s.I == nil || m.Rules == nil {
+		return s
+	}
+	if state, ok := m.Rules.StateNamed(s.String()); ok && state.ID() != s.ID() {
+		return state
+	}
+	return s
+}
@@ -0,0 +1,89 @@
+package fsm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestMachineSnapshot(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+	)
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithHistory())
+	m.Rules = &rules
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, m.Transition(stateFinished), nil)
+
+	data, err := m.Snapshot()
+	st.Expect(t, err, nil)
+
+	restored := fsm.New(fsm.WithHistory(), fsm.WithStrictStates())
+	restored.Rules = &rules
+	st.Expect(t, restored.Restore(data), nil)
+	st.Expect(t, restored.Current(), stateFinished)
+	history := restored.History()
+	st.Expect(t, len(history), 2)
+	st.Expect(t, history[0].From, statePending)
+	st.Expect(t, history[0].To, stateStarted)
+	st.Expect(t, history[1].To, stateFinished)
+	st.Expect(t, history[1].Time.Equal(m.History()[1].Time), true)
+	st.Expect(t, restored.Rollback(), nil)
+	st.Expect(t, restored.Current(), stateStarted)
+
+	// the history is cut to the limit of the machine
+	limited := fsm.New(fsm.WithHistoryLimit(1))
+	st.Expect(t, limited.Restore(data), nil)
+	st.Expect(t, len(limited.History()), 1)
+	st.Expect(t, limited.History()[0].To, stateFinished)
+
+	// strict machines reject unknown states
+	other := fsm.CreateRuleset(fsm.NewTransition(statePending, stateStarted))
+	strict := fsm.New(fsm.WithInitialState(statePending), fsm.WithStrictStates())
+	strict.Rules = &other
+	st.Expect(t, errors.Is(strict.Restore(data), fsm.ErrUnknownState), true)
+	st.Expect(t, strict.Current(), statePending)
+
+	st.Expect(t, strict.Restore([]byte(`{"state":""}`)), fsm.ErrEmptyState)
+}
+
+// flowState is an IDer whose ID is a plain string, like in the Readme
+type flowState struct{ name string }
+
+func (f flowState) ID() fsm.ID { return f.name }
+
+func TestMachineRestoreStringIDs(t *testing.T) {
+	a, b := fsm.NewState(flowState{"a"}), fsm.NewState(flowState{"b"})
+	rules := fsm.CreateRuleset(fsm.NewTransition(a, b))
+	m := fsm.New(fsm.WithInitialState(a), fsm.WithRules(&rules), fsm.WithHistory())
+	data, err := m.Snapshot()
+	st.Expect(t, err, nil)
+
+	restored := fsm.New(fsm.WithRules(&rules), fsm.WithHistory())
+	st.Expect(t, restored.Restore(data), nil)
+	st.Expect(t, restored.Current().ID(), fsm.ID("a"))
+	st.Expect(t, restored.Transition(b), nil)
+	st.Expect(t, restored.History()[0].From.ID(), fsm.ID("a"))
+
+	// scanned states are resolved the same way
+	v, _ := a.Value()
+	var s fsm.State
+	st.Expect(t, s.Scan(v), nil)
+	resolved, ok := rules.StateNamed(s.String())
+	st.Expect(t, ok, true)
+	st.Expect(t, resolved.ID(), a.ID())
+	_, ok = rules.StateNamed("c")
+	st.Expect(t, ok, false)
+
+	// typed machines too
+	typed := fsm.TypedRuleset[int]{}
+	typed.AddTransition(1, 2)
+	tm := fsm.New(fsm.WithInitialState(fsm.TypedState(1)), fsm.WithRules(typed.Ruleset()))
+	data, err = tm.Snapshot()
+	st.Expect(t, err, nil)
+	st.Expect(t, tm.Restore(data), nil)
+	st.Expect(t, tm.Transition(fsm.TypedState(2)), nil)
+}
@@ -1,5 +1,22 @@
 package fsm
 
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	errScanFormat = "cannot scan %T into a State"
+)
+
+var (
+	// ErrEmptyState is returned when decoding an empty state
+	ErrEmptyState = errors.New("empty state")
+)
+
 // ID is the type of what will be used to compare between states
 type ID interface{}
 
@@ -23,11 +40,46 @@ func NewState(i IDer) State {
 	}
 }
 
+// AnyState is a wildcard origin: a transition from AnyState permits moving
+// to its exit from any state, when no rule exists for the exact origin.
+var AnyState = NewState(anyState{})
+
+// anyState is the IDer and ID of AnyState
+type anyState struct{}
+
+func (a anyState) ID() ID { return a }
+
+func (a anyState) String() string { return "*" }
+
+// IDState creates a State only carrying the given id, for when the
+// associated data isn't known (e.g. goals built from a Ruleset).
+func IDState(id ID) State {
+	return NewState(idOnly{id})
+}
+
+// idOnly is the IDer used by IDState
+type idOnly struct{ id ID }
+
+func (i idOnly) ID() ID { return i.id }
+
 // ID returns the id of the state
 func (s State) ID() ID {
 	return s.I.(IDer).ID()
 }
 
+// String returns the ID of the state as a string, "<nil>" for the zero State
+func (s State) String() string {
+	return fmt.Sprint(stateID(s))
+}
+
+// stateID is like s.ID() but returns nil for the zero State
+func stateID(s State) ID {
+	if s.I == nil {
+		return nil
+	}
+	return s.ID()
+}
+
 // IDer describes an interface that can return an ID for
 // the transitions to take place, (e.g. id:'pending'->id:'started').
 type IDer interface {
@@ -43,3 +95,101 @@ type String string
 func (s String) ID() ID {
 	return s
 }
+
+// MarshalJSON encodes the ID of the state as a string, like Value, so that
+// it can always be decoded by UnmarshalJSON. The zero State is encoded as
+// null.
+func (s State) MarshalJSON() ([]byte, error) {
+	if s.I == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(fmt.Sprint(s.ID()))
+}
+
+// UnmarshalJSON decodes a state encoded by MarshalJSON. The ID has to be
+// a non-empty string and is loaded as a String, other data carried by
+// the state isn't restored, see Ruleset.StateNamed for rules using other
+// IDs. null leaves the state unchanged.
+func (s *State) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var id string
+	if err := json.Unmarshal(data, &id); err != nil {
+		return err
+	}
+	if id == "" {
+		return ErrEmptyState
+	}
+	*s = NewState(String(id))
+	return nil
+}
+
+// Value implements driver.Valuer, storing the ID of the state as a string.
+// The zero State is stored as NULL.
+func (s State) Value() (driver.Value, error) {
+	if s.I == nil {
+		return nil, nil
+	}
+	return fmt.Sprint(s.ID()), nil
+}
+
+// Scan implements sql.Scanner, loading the state as a String from a
+// non-empty string or []byte column, see Ruleset.StateNamed for rules
+// using other IDs
+func (s *State) Scan(src interface{}) error {
+	var id string
+	switch v := src.(type) {
+	case string:
+		id = v
+	case []byte:
+		id = string(v)
+	case nil:
+		return ErrEmptyState
+	default:
+		return fmt.Errorf(errScanFormat, src)
+	}
+	if id == "" {
+		return ErrEmptyState
+	}
+	*s = NewState(String(id))
+	return nil
+}
+
+// SafeState holds a State which can be read and changed from multiple
+// goroutines, for when states are driven by a shared Ruleset rather than
+// a Machine (which does its own locking)
+type SafeState struct {
+	mu sync.RWMutex
+	s  State
+}
+
+// NewSafeState creates a SafeState holding the initial state
+func NewSafeState(initial State) *SafeState {
+	return &SafeState{s: initial}
+}
+
+// Current returns the held state
+func (s *SafeState) Current() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.s
+}
+
+// Set replaces the held state, without checking any rule
+func (s *SafeState) Set(state State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.s = state
+}
+
+// Transition moves the held state to the goal if the rules permit it,
+// the check and the change being atomic, see Ruleset.Transition
+func (s *SafeState) Transition(r *Ruleset, goal State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return r.Transition(&s.s, goal)
+}
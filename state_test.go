@@ -0,0 +1,115 @@
+package fsm_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+var (
+	_ json.Marshaler   = fsm.State{}
+	_ json.Unmarshaler = &fsm.State{}
+	_ driver.Valuer    = fsm.State{}
+	_ sql.Scanner      = &fsm.State{}
+)
+
+type order struct {
+	ID    int       `json:"id"`
+	State fsm.State `json:"state"`
+}
+
+func TestStateJSON(t *testing.T) {
+	data, err := json.Marshal(order{ID: 1, State: statePending})
+	st.Expect(t, err, nil)
+	st.Expect(t, string(data), `{"id":1,"state":"pending"}`)
+
+	var o order
+	st.Expect(t, json.Unmarshal(data, &o), nil)
+	st.Expect(t, o.State, statePending)
+
+	data, err = json.Marshal(order{})
+	st.Expect(t, err, nil)
+	st.Expect(t, string(data), `{"id":0,"state":null}`)
+
+	st.Expect(t, json.Unmarshal([]byte(`{"state":""}`), &o), fsm.ErrEmptyState)
+	st.Reject(t, json.Unmarshal([]byte(`{"state":12}`), &o), nil)
+
+	// IDs which aren't strings are encoded as strings, like by Value
+	for _, s := range []fsm.State{fsm.TypedState(3), fsm.AnyState} {
+		data, err = json.Marshal(order{State: s})
+		st.Expect(t, err, nil)
+		v, _ := s.Value()
+		st.Expect(t, string(data), `{"id":0,"state":"`+v.(string)+`"}`)
+		st.Expect(t, json.Unmarshal(data, &o), nil)
+		st.Expect(t, o.State.String(), s.String())
+	}
+}
+
+func TestStateSQL(t *testing.T) {
+	v, err := statePending.Value()
+	st.Expect(t, err, nil)
+	st.Expect(t, v, driver.Value("pending"))
+
+	v, err = fsm.State{}.Value()
+	st.Expect(t, err, nil)
+	st.Expect(t, v, nil)
+
+	var s fsm.State
+	st.Expect(t, s.Scan("pending"), nil)
+	st.Expect(t, s, statePending)
+	st.Expect(t, s.Scan([]byte("started")), nil)
+	st.Expect(t, s, stateStarted)
+
+	st.Expect(t, s.Scan(""), fsm.ErrEmptyState)
+	st.Expect(t, s.Scan(nil), fsm.ErrEmptyState)
+	st.Reject(t, s.Scan(42), nil)
+	st.Expect(t, s, stateStarted)
+}
+
+func TestSafeState(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, statePending),
+	)
+	s := fsm.NewSafeState(statePending)
+
+	var wg sync.WaitGroup
+	var moves int64
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			goal := stateStarted
+			if i%2 == 0 {
+				goal = statePending
+			}
+			for j := 0; j < 50; j++ {
+				if s.Transition(&rules, goal) == nil {
+					atomic.AddInt64(&moves, 1)
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_ = s.Current().ID()
+			}
+		}()
+	}
+	wg.Wait()
+
+	expected := statePending
+	if moves%2 == 1 {
+		expected = stateStarted
+	}
+	st.Expect(t, s.Current(), expected)
+
+	s.Set(stateFinished)
+	st.Expect(t, s.Current(), stateFinished)
+}
@@ -0,0 +1,60 @@
+package fsm
+
+// TransitionStats counts the attempts of a transition, see Machine.Stats
+type TransitionStats struct {
+	// Attempts is the number of times the guards of the transition were run
+	Attempts int
+	// Allowed and Denied are the number of attempts which were permitted
+	// and which weren't
+	Allowed int
+	Denied  int
+}
+
+// WithStats makes the machine count the attempts of every transition, see
+// Stats. Machines created by For and Clone start with no count.
+func WithStats() func(*Machine) {
+	return func(m *Machine) {
+		m.stats = map[T]TransitionStats{}
+	}
+}
+
+// Stats returns the counts of the transitions attempted since the machine
+// was created or ResetStats was called, keyed by transition. Attempts are
+// counted like they are reported to an Observer: once the machine checked
+// the goal is valid, when running the guards. The map is empty unless the
+// machine was created with WithStats.
+func (m *Machine) Stats() map[Transition]TransitionStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make(map[Transition]TransitionStats, len(m.stats))
+	for t, s := range m.stats {
+		stats[t] = s
+	}
+	return stats
+}
+
+// ResetStats sets all the counts back to zero
+func (m *Machine) ResetStats() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stats != nil {
+		m.stats = map[T]TransitionStats{}
+	}
+}
+
+// count records an attempt of t, the machine must be locked
+func (m *Machine) count(t T, err error) {
+	if m.stats == nil {
+		return
+	}
+	s := m.stats[t]
+	s.Attempts++
+	if err != nil {
+		s.Denied++
+	} else {
+		s.Allowed++
+	}
+	m.stats[t] = s
+}
@@ -0,0 +1,49 @@
+package fsm_test
+
+import (
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestMachineStats(t *testing.T) {
+	allowed := false
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, statePending),
+	)
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		if !allowed {
+			return testError
+		}
+		return nil
+	})
+
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithStats())
+	m.Rules = &rules
+	st.Reject(t, m.Transition(stateStarted), nil)
+	st.Reject(t, m.Transition(stateStarted), nil)
+	allowed = true
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, m.Transition(statePending), nil)
+	st.Reject(t, m.Transition(stateFinished), nil)
+
+	st.Expect(t, m.Stats(), map[fsm.Transition]fsm.TransitionStats{
+		fsm.NewTransition(statePending, stateStarted):  {Attempts: 3, Allowed: 1, Denied: 2},
+		fsm.NewTransition(stateStarted, statePending):  {Attempts: 1, Allowed: 1},
+		fsm.NewTransition(statePending, stateFinished): {Attempts: 1, Denied: 1},
+	})
+	st.Expect(t, len(m.For(statePending).Stats()), 0)
+
+	m.ResetStats()
+	st.Expect(t, len(m.Stats()), 0)
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, m.Stats()[fsm.NewTransition(statePending, stateStarted)], fsm.TransitionStats{Attempts: 1, Allowed: 1})
+
+	// without WithStats nothing is counted
+	n := fsm.New(fsm.WithInitialState(statePending))
+	n.Rules = &rules
+	st.Expect(t, n.Transition(stateStarted), nil)
+	st.Expect(t, n.Stats(), map[fsm.Transition]fsm.TransitionStats{})
+}
@@ -0,0 +1,69 @@
+package fsm
+
+import "time"
+
+// SubscriptionBuffer is the size of the channels returned by Subscribe,
+// once a subscriber is that many events behind new events are dropped
+// for it, see DroppedEvents
+const SubscriptionBuffer = 64
+
+// TransitionEvent is published to subscribers for every successful
+// transition of a Machine
+type TransitionEvent struct {
+	From State
+	To   State
+	Time time.Time
+}
+
+// Subscribe returns a channel receiving an event for every successful
+// transition, until Unsubscribe is called. Events are never waited on:
+// a transition drops the event for a subscriber whose buffer is full
+// rather than blocking.
+func (m *Machine) Subscribe() <-chan TransitionEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch := make(chan TransitionEvent, SubscriptionBuffer)
+	m.subscribers = append(m.subscribers, ch)
+	return ch
+}
+
+// Unsubscribe stops sending events to ch and closes it, unknown
+// channels are ignored
+func (m *Machine) Unsubscribe(ch <-chan TransitionEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, sub := range m.subscribers {
+		if sub == ch {
+			close(sub)
+			m.subscribers = append(m.subscribers[:i], m.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// DroppedEvents returns the number of events dropped because
+// a subscriber wasn't keeping up
+func (m *Machine) DroppedEvents() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.dropped
+}
+
+// publish sends the event to every subscriber without blocking,
+// the machine must be locked
+func (m *Machine) publish(from, to State) {
+	if len(m.subscribers) == 0 {
+		return
+	}
+	e := TransitionEvent{From: from, To: to, Time: time.Now()}
+	for _, sub := range m.subscribers {
+		select {
+		case sub <- e:
+		default:
+			m.dropped++
+		}
+	}
+}
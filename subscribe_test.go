@@ -0,0 +1,66 @@
+package fsm_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestMachineSubscribe(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, statePending),
+	)
+	m := fsm.New(fsm.WithInitialState(statePending))
+	m.Rules = &rules
+
+	subs := []<-chan fsm.TransitionEvent{m.Subscribe(), m.Subscribe()}
+	var wg sync.WaitGroup
+	received := make([][]fsm.ID, len(subs))
+	for i, sub := range subs {
+		wg.Add(1)
+		go func(i int, sub <-chan fsm.TransitionEvent) {
+			defer wg.Done()
+			for e := range sub {
+				received[i] = append(received[i], e.To.ID())
+				st.Expect(t, e.Time.IsZero(), false)
+			}
+		}(i, sub)
+	}
+
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Reject(t, m.Transition(stateFinished), nil)
+	st.Expect(t, m.Transition(statePending), nil)
+	for _, sub := range subs {
+		m.Unsubscribe(sub)
+	}
+	wg.Wait()
+
+	for _, r := range received {
+		st.Expect(t, r, []fsm.ID{stateStarted.ID(), statePending.ID()})
+	}
+	st.Expect(t, m.DroppedEvents(), uint64(0))
+}
+
+func TestMachineSubscribeSlowConsumer(t *testing.T) {
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, statePending),
+	)
+	m := fsm.New(fsm.WithInitialState(statePending))
+	m.Rules = &rules
+
+	// nobody reads, transitions must not block
+	sub := m.Subscribe()
+	goals := []fsm.State{stateStarted, statePending}
+	for i := 0; i < fsm.SubscriptionBuffer+10; i++ {
+		st.Expect(t, m.Transition(goals[i%2]), nil)
+	}
+	st.Expect(t, len(sub), fsm.SubscriptionBuffer)
+	st.Expect(t, m.DroppedEvents(), uint64(10))
+
+	m.Unsubscribe(sub)
+	m.Unsubscribe(sub)
+}
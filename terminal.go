@@ -0,0 +1,44 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	errTerminalFormat = "%w %s"
+)
+
+var (
+	// ErrTerminalState is returned when leaving a terminal state, or
+	// registering a transition from one, see MarkTerminal
+	ErrTerminalState = errors.New("terminal state")
+)
+
+// MarkTerminal marks states as terminal (e.g. "cancelled", "completed"):
+// machines never leave them, transitions from them failing with an error
+// wrapping ErrTerminalState. Rules from them added afterwards are rejected:
+// AddTransitionStrict returns the error and the other methods adding rules
+// record it for Err. Validate reports the transitions leaving them which
+// were added before.
+func (r *Ruleset) MarkTerminal(states ...IDer) {
+	if r.terminal == nil {
+		r.terminal = map[ID]bool{}
+	}
+	for _, s := range states {
+		r.terminal[s.ID()] = true
+	}
+}
+
+// IsTerminal reports whether the state was marked terminal
+func (r *Ruleset) IsTerminal(s IDer) bool {
+	return r.terminal[s.ID()]
+}
+
+// checkTerminal returns an error wrapping ErrTerminalState if id is terminal
+func (r *Ruleset) checkTerminal(id ID) error {
+	if r.terminal[id] {
+		return fmt.Errorf(errTerminalFormat, ErrTerminalState, id)
+	}
+	return nil
+}
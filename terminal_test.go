@@ -0,0 +1,62 @@
+package fsm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestRulesetTerminal(t *testing.T) {
+	cancelled := fsm.NewState(fsm.String("cancelled"))
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(stateStarted, stateFinished),
+		fsm.NewTransition(fsm.AnyState, cancelled),
+	)
+	rules.MarkTerminal(stateFinished, cancelled)
+	st.Expect(t, rules.IsTerminal(cancelled), true)
+	st.Expect(t, rules.IsTerminal(stateStarted), false)
+
+	// at registration time
+	err := rules.AddTransitionStrict(fsm.NewTransition(stateFinished, statePending))
+	st.Expect(t, errors.Is(err, fsm.ErrTerminalState), true)
+	st.Expect(t, err.Error(), "terminal state finished")
+	st.Expect(t, rules.HasRule(fsm.NewTransition(stateFinished, statePending)), false)
+
+	v, err := rules.Validate(statePending)
+	st.Expect(t, err, nil)
+	st.Expect(t, v.LeavingTerminal, []fsm.ID(nil))
+
+	// at transition time, including for wildcard transitions
+	m := fsm.New(fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	st.Expect(t, m.Transition(stateStarted), nil)
+	st.Expect(t, m.Transition(stateFinished), nil)
+	st.Expect(t, errors.Is(m.Transition(cancelled), fsm.ErrTerminalState), true)
+	st.Expect(t, m.AvailableTransitions(), []fsm.ID{})
+	st.Expect(t, m.Current(), stateFinished)
+
+	// other rules from terminal states are rejected and recorded
+	rules.AddTransition(fsm.NewTransition(stateFinished, statePending))
+	st.Expect(t, rules.HasRule(fsm.NewTransition(stateFinished, statePending)), false)
+	st.Expect(t, errors.Is(rules.Err(), fsm.ErrTerminalState), true)
+	st.Expect(t, errors.Is(m.Validate(), fsm.ErrTerminalState), true)
+	st.Expect(t, errors.Is(m.Transition(statePending), fsm.ErrTerminalState), true)
+
+	// validation flags terminal states having transitions added before
+	leaving := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateFinished),
+		fsm.NewTransition(stateFinished, statePending),
+	)
+	leaving.MarkTerminal(stateFinished)
+	st.Expect(t, leaving.Err(), nil)
+	v, err = leaving.Validate(statePending)
+	st.Expect(t, errors.Is(err, fsm.ErrTerminalState), true)
+	st.Expect(t, v.LeavingTerminal, []fsm.ID{stateFinished.ID()})
+
+	// terminal states are cloned
+	c := rules.Clone()
+	st.Expect(t, c.IsTerminal(cancelled), true)
+}
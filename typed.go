@@ -0,0 +1,85 @@
+package fsm
+
+import "context"
+
+// TypedGuard is a Guard for a TypedRuleset, receiving the states as S
+type TypedGuard[S comparable] func(start S, goal S) error
+
+// TypedRuleset is a Ruleset whose states are values of S, e.g. a
+// type OrderState string with named constants, so that using a state of
+// another type doesn't compile. The zero value is ready to be used.
+type TypedRuleset[S comparable] struct {
+	rules Ruleset
+}
+
+// typed is the IDer of the states of a TypedRuleset, the ID is the
+// value itself
+type typed[S comparable] struct{ s S }
+
+func (t typed[S]) ID() ID { return t.s }
+
+// TypedState returns the State of a TypedRuleset for s
+func TypedState[S comparable](s S) State {
+	return NewState(typed[S]{s})
+}
+
+// AddTransition adds a transition from one state to another with the
+// default rule, see Ruleset.AddTransition
+func (r *TypedRuleset[S]) AddTransition(from, to S) {
+	r.rules.AddTransition(T{from, to})
+}
+
+// AddRule adds guards for the transition from one state to another
+func (r *TypedRuleset[S]) AddRule(from, to S, guards ...TypedGuard[S]) {
+	for _, guard := range guards {
+		guard := guard
+		r.rules.AddRuleCtx(T{from, to}, func(ctx context.Context, start *State, goal *State) error {
+			return guard(start.ID().(S), goal.ID().(S))
+		})
+	}
+}
+
+// Permitted determines if the transition from start to goal is allowed,
+// see Ruleset.Permitted
+func (r *TypedRuleset[S]) Permitted(start, goal S) error {
+	s, g := TypedState(start), TypedState(goal)
+	return r.rules.Permitted(&s, &g)
+}
+
+// Ruleset returns the underlying untyped ruleset, to use the features
+// not exposed by TypedRuleset. Its states are made with TypedState.
+func (r *TypedRuleset[S]) Ruleset() *Ruleset {
+	return &r.rules
+}
+
+// TypedMachine is a Machine whose states are values of S,
+// see TypedRuleset
+type TypedMachine[S comparable] struct {
+	m *Machine
+}
+
+// NewTyped initializes a machine using the rules, starting at the
+// initial state
+func NewTyped[S comparable](rules *TypedRuleset[S], initial S, opts ...func(*Machine)) *TypedMachine[S] {
+	m := New(opts...)
+	m.Rules = rules.Ruleset()
+	m.State = TypedState(initial)
+	return &TypedMachine[S]{m: m}
+}
+
+// Transition attempts to move the machine to the goal state,
+// see Machine.Transition
+func (m *TypedMachine[S]) Transition(goal S) error {
+	return m.m.Transition(TypedState(goal))
+}
+
+// Current returns the current state of the machine
+func (m *TypedMachine[S]) Current() S {
+	return m.m.Current().ID().(S)
+}
+
+// Machine returns the underlying untyped machine, whose states are made
+// with TypedState
+func (m *TypedMachine[S]) Machine() *Machine {
+	return m.m
+}
@@ -0,0 +1,48 @@
+package fsm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+type orderState string
+
+const (
+	orderPending orderState = "pending"
+	orderPaid    orderState = "paid"
+	orderShipped orderState = "shipped"
+)
+
+func TestTypedMachine(t *testing.T) {
+	var rules fsm.TypedRuleset[orderState]
+	rules.AddTransition(orderPending, orderPaid)
+	rules.AddTransition(orderPaid, orderShipped)
+
+	stock := 0
+	rules.AddRule(orderPaid, orderShipped, func(start orderState, goal orderState) error {
+		st.Expect(t, start, orderPaid)
+		st.Expect(t, goal, orderShipped)
+		if stock == 0 {
+			return testError
+		}
+		return nil
+	})
+
+	st.Expect(t, rules.Permitted(orderPending, orderPaid), nil)
+	st.Reject(t, rules.Permitted(orderPending, orderShipped), nil)
+
+	m := fsm.NewTyped(&rules, orderPending)
+	st.Expect(t, m.Transition(orderPaid), nil)
+	st.Expect(t, m.Current(), orderPaid)
+
+	st.Expect(t, errors.Is(m.Transition(orderShipped), testError), true)
+	st.Expect(t, m.Current(), orderPaid)
+
+	stock = 1
+	st.Expect(t, m.Transition(orderShipped), nil)
+	st.Expect(t, m.Current(), orderShipped)
+	st.Expect(t, m.Machine().Current(), fsm.TypedState(orderShipped))
+}
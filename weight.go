@@ -0,0 +1,89 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+)
+
+const (
+	errNoStepFormat = "%w permitted from %s"
+)
+
+// SetWeight sets the weight of the transition, used by Machine.Step to pick
+// between the transitions permitted. Transitions have a weight of 1 unless
+// set, and are never picked with a weight <= 0.
+func (r *Ruleset) SetWeight(t Transition, w float64) {
+	if r.weights == nil {
+		r.weights = map[T]float64{}
+	}
+	r.weights[key(t)] = w
+}
+
+// weight returns the weight of the rule t
+func (r *Ruleset) weight(t T) float64 {
+	if w, ok := r.weights[t]; ok {
+		return w
+	}
+	return 1
+}
+
+// Step performs one of the transitions permitted from the current state,
+// picked at random by rng according to their weights (see SetWeight), and
+// returns the state entered which, as rules only know about IDs, only
+// carries its ID. The same rng seed and rules always give the same
+// choices, which makes Step suited for simulations and fuzzing. If nothing
+// is permitted the state is left unchanged and an error wrapping
+// ErrNoOutgoing is returned, ErrTerminalState from a terminal state.
+// The transitions are checked like CanTransition does, the guards of each
+// one being run once: the one picked is then performed without running
+// them again, and reported to the observer as attempted and allowed.
+func (m *Machine) Step(rng *rand.Rand) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.validate(); err != nil {
+		return m.State, err
+	}
+	if err := m.Rules.checkTerminal(m.State.ID()); err != nil {
+		return m.State, err
+	}
+	ctx := context.Background()
+	var goals []State
+	var weights []float64
+	total := 0.0
+	for _, t := range m.Rules.candidates(m.State.ID()) {
+		w := m.Rules.weight(t)
+		goal := IDState(t.E)
+		if w <= 0 || m.precheck(goal) != nil || m.permits(ctx, goal) != nil {
+			continue
+		}
+		goals = append(goals, goal)
+		weights = append(weights, w)
+		total += w
+	}
+	if len(goals) == 0 {
+		return m.State, fmt.Errorf(errNoStepFormat, ErrNoOutgoing, m.State.ID())
+	}
+
+	pick := goals[len(goals)-1]
+	x := rng.Float64() * total
+	for i, w := range weights {
+		if x < w {
+			pick = goals[i]
+			break
+		}
+		x -= w
+	}
+	if m.idempotent && m.State.ID() == pick.ID() {
+		return m.State, nil
+	}
+	t := T{m.State.ID(), pick.ID()}
+	if m.observer != nil {
+		m.observer.TransitionAttempted(t)
+		m.observer.TransitionAllowed(t)
+	}
+	m.count(t, nil)
+	m.enter(ctx, pick)
+	return m.State, nil
+}
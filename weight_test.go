@@ -0,0 +1,89 @@
+package fsm_test
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+)
+
+func TestMachineStep(t *testing.T) {
+	cancelled := fsm.NewState(fsm.String("cancelled"))
+	rules := fsm.CreateRuleset(
+		fsm.NewTransition(statePending, stateStarted),
+		fsm.NewTransition(statePending, cancelled),
+		fsm.NewTransition(statePending, stateFinished),
+	)
+	rules.AddRule(fsm.NewTransition(statePending, stateFinished), func(start *fsm.State, goal *fsm.State) error {
+		return testError
+	})
+
+	walk := func(seed int64) []fsm.ID {
+		rng := rand.New(rand.NewSource(seed))
+		m := fsm.New(fsm.WithInitialState(statePending))
+		m.Rules = &rules
+		var ids []fsm.ID
+		for i := 0; i < 20; i++ {
+			st.Expect(t, m.Reset(), nil)
+			s, err := m.Step(rng)
+			st.Expect(t, err, nil)
+			st.Expect(t, m.Current().ID(), s.ID())
+			ids = append(ids, s.ID())
+		}
+		return ids
+	}
+	st.Expect(t, walk(42), walk(42))
+	for _, id := range walk(7) {
+		st.Reject(t, id, stateFinished.ID())
+	}
+
+	// weights bias the selection
+	rules.SetWeight(fsm.NewTransition(statePending, cancelled), 9)
+	rng := rand.New(rand.NewSource(1))
+	m := fsm.New(fsm.WithInitialState(statePending))
+	m.Rules = &rules
+	counts := map[fsm.ID]int{}
+	for i := 0; i < 1000; i++ {
+		st.Expect(t, m.Reset(), nil)
+		s, _ := m.Step(rng)
+		counts[s.ID()]++
+	}
+	st.Expect(t, counts[cancelled.ID()] > 800, true)
+	st.Expect(t, counts[stateStarted.ID()] > 50, true)
+
+	rules.SetWeight(fsm.NewTransition(statePending, stateStarted), 0)
+	st.Expect(t, m.Reset(), nil)
+	s, _ := m.Step(rng)
+	st.Expect(t, s.ID(), cancelled.ID())
+
+	// nothing permitted
+	n := fsm.New(fsm.WithInitialState(stateFinished))
+	n.Rules = &rules
+	s, err := n.Step(rng)
+	st.Expect(t, errors.Is(err, fsm.ErrNoOutgoing), true)
+	st.Expect(t, err.Error(), "no outgoing transitions permitted from finished")
+	st.Expect(t, s, stateFinished)
+
+	// from terminal states
+	rules.MarkTerminal(stateFinished)
+	rules.AddTransition(fsm.NewTransition(stateFinished, statePending))
+	_, err = n.Step(rng)
+	st.Expect(t, errors.Is(err, fsm.ErrTerminalState), true)
+}
+
+func TestMachineStepGuardsRunOnce(t *testing.T) {
+	calls := 0
+	rules := fsm.Ruleset{}
+	rules.AddRule(fsm.NewTransition(statePending, stateStarted), func(start *fsm.State, goal *fsm.State) error {
+		calls++
+		return nil
+	})
+	m := fsm.New(fsm.WithInitialState(statePending), fsm.WithRules(&rules), fsm.WithStats())
+	s, err := m.Step(rand.New(rand.NewSource(1)))
+	st.Expect(t, err, nil)
+	st.Expect(t, s.ID(), stateStarted.ID())
+	st.Expect(t, calls, 1)
+	st.Expect(t, m.Stats()[fsm.NewTransition(statePending, stateStarted)].Allowed, 1)
+}
@@ -0,0 +1,33 @@
+// Package yaml loads fsm rulesets from YAML documents, binding the guards
+// they reference by name to functions registered by the program:
+//
+//	transitions:
+//	  - from: pending
+//	    to: started
+//	    guards: [paid]
+//	  - from: "*"
+//	    to: cancelled
+package yaml
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/processout/fsm"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	errLoadFormat = "%w: %s"
+)
+
+// Load builds a ruleset from a YAML document describing a fsm.Definition.
+// The guards named by the transitions are looked up in the registry, see
+// fsm.Definition.Ruleset, and decoding errors wrap fsm.ErrInvalidRuleset.
+func Load(r io.Reader, registry map[string]fsm.Guard) (fsm.Ruleset, error) {
+	var doc fsm.Definition
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		return fsm.Ruleset{}, fmt.Errorf(errLoadFormat, fsm.ErrInvalidRuleset, err)
+	}
+	return doc.Ruleset(registry)
+}
@@ -0,0 +1,67 @@
+package yaml_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nbio/st"
+	"github.com/processout/fsm"
+	"github.com/processout/fsm/yaml"
+)
+
+func TestLoad(t *testing.T) {
+	paid, stocked := true, true
+	registry := map[string]fsm.Guard{
+		"paid": func(start *fsm.State, goal *fsm.State) error {
+			if !paid {
+				return fsm.Deny("not paid")
+			}
+			return nil
+		},
+		"stocked": func(start *fsm.State, goal *fsm.State) error {
+			if !stocked {
+				return fsm.Deny("out of stock")
+			}
+			return nil
+		},
+	}
+
+	rules, err := yaml.Load(strings.NewReader(`
+transitions:
+  - from: pending
+    to: started
+    guards: [paid, stocked]
+  - from: started
+    to: finished
+  - from: "*"
+    to: cancelled
+`), registry)
+	st.Assert(t, err, nil)
+
+	pending, started := fsm.NewState(fsm.String("pending")), fsm.NewState(fsm.String("started"))
+	finished, cancelled := fsm.NewState(fsm.String("finished")), fsm.NewState(fsm.String("cancelled"))
+	st.Expect(t, rules.Permitted(&pending, &started), nil)
+	st.Expect(t, rules.Permitted(&started, &finished), nil)
+	st.Expect(t, rules.Permitted(&finished, &cancelled), nil)
+	st.Reject(t, rules.Permitted(&pending, &finished), nil)
+
+	stocked = false
+	var terr *fsm.TransitionError
+	st.Expect(t, errors.As(rules.Permitted(&pending, &started), &terr), true)
+	st.Expect(t, terr.Name, "stocked")
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := yaml.Load(strings.NewReader("transitions: ["), nil)
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidRuleset), true)
+
+	_, err = yaml.Load(strings.NewReader(`
+transitions:
+  - from: pending
+    to: started
+    guards: [paid, stocked]
+`), map[string]fsm.Guard{})
+	st.Expect(t, errors.Is(err, fsm.ErrInvalidRuleset), true)
+	st.Expect(t, err.Error(), "invalid ruleset: unknown guards paid, stocked")
+}